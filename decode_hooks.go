@@ -72,7 +72,7 @@ func ComposeDecodeHookFunc(fs ...DecodeHookFunc) DecodeHookFunc {
 			}
 
 			// Modify the from kind to be correct with the new data
-			f = reflect.ValueOf(data).Type()
+			f = typeOf(data)
 		}
 
 		return data, nil
@@ -81,6 +81,9 @@ func ComposeDecodeHookFunc(fs ...DecodeHookFunc) DecodeHookFunc {
 
 // StringToSliceHookFunc returns a DecodeHookFunc that converts
 // string to []string by splitting on the given sep.
+//
+// To split only some fields, or to trim the elements, use the "sep" and
+// "trim" tag options instead, for example `mapstructure:"hosts,sep=;,trim"`.
 func StringToSliceHookFunc(sep string) DecodeHookFunc {
 	return func(
 		f reflect.Kind,
@@ -8,12 +8,17 @@
 package mapstructure
 
 import (
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/pschlump/dbgo"
 )
@@ -43,18 +48,48 @@ type DecoderConfig struct {
 	// type conversion (if WeaklyTypedInput is on). This lets you modify
 	// the values before they're set down onto the resulting struct.
 	//
+	// The hook is also called for nil data, with the empty interface
+	// type as the "from" type, so it can replace nil with a value.
+	//
 	// If an error is returned, the entire decode will fail with that
 	// error.
 	DecodeHook DecodeHookFunc
 
+	// SliceMerge selects how a slice that already has elements is
+	// combined with the decoded data when ZeroFields is false. By default
+	// the slice is replaced. A field can override this with the "merge"
+	// tag option, or merge by a key with the "mergekey" tag option, see
+	// TagName.
+	SliceMerge SliceMerge
+
+	// NilBehavior controls what happens when the data for a value is nil,
+	// for example a key set to null in JSON. By default the value is left
+	// untouched. A field can override this with the "nil" tag option,
+	// for example `mapstructure:"timeout,nil=zero"`.
+	NilBehavior NilBehavior
+
 	// If ErrorUnused is true, then it is an error for there to exist
 	// keys in the original map that were unused in the decoding process
 	// (extra keys).
 	ErrorUnused bool
 
+	// PairList, if set, lets a map be decoded from a list of key/value
+	// pairs, such as [{"key": "a", "value": 1}] or ["a=1"], instead of
+	// from a map. It only applies to lists whose elements all look like
+	// pairs, and not to sets, so other lists are decoded as before. A
+	// field can enable this for any list with the "pairs" tag option,
+	// which uses the default PairList if none is set here.
+	PairList *PairList
+
+	// If ErrorDuplicates is true, then it is an error for a list decoded
+	// into a set (a map with struct{} or bool values) to have the same
+	// element more than once.
+	ErrorDuplicates bool
+
 	// ZeroFields, if set to true, will zero fields before writing them.
 	// For example, a map will be emptied before decoded values are put in
-	// it. If this is false, a map will be merged.
+	// it. If this is false, a map will be merged, and a non-nil pointer
+	// will be decoded into rather than replaced.
 	ZeroFields bool
 
 	// If WeaklyTypedInput is true, the decoder will make the following
@@ -71,8 +106,44 @@ type DecoderConfig struct {
 	//   - negative numbers to overflowed uint values (base 10)
 	//   - slice of maps to a merged map
 	//
+	// WeaklyTypedInput is a preset that enables every conversion in
+	// WeakConversions.
 	WeaklyTypedInput bool
 
+	// WeakConversions selects individual "weak" conversions. This lets
+	// you enable, for example, strings to numbers without also allowing
+	// negative numbers to wrap around into uints. If WeaklyTypedInput is
+	// true, this is set to WeakAll.
+	WeakConversions WeakConversion
+
+	// If CoerceSingletons is true, a single value that is not a list is
+	// accepted where a slice is expected and decoded as a one-element
+	// slice. In the other direction, a one-element list is accepted where
+	// a bool, string or number is expected and its element is decoded.
+	CoerceSingletons bool
+
+	// BoolVocabulary, if set, is the set of words accepted when decoding
+	// a string into a bool, replacing the ones strconv.ParseBool accepts.
+	// Setting it enables string to bool decoding even if weak conversions
+	// are off, but an empty string is only false with WeakStringToBool.
+	// ExtendedBoolVocabulary returns a preset with common words such as
+	// "yes", "on" and "enabled".
+	//
+	// A field can override the words with the "true" and "false" tag
+	// options, each a list of words separated by "|":
+	//
+	//   Debug bool `mapstructure:"debug,true=yes|on,false=no|off"`
+	//
+	BoolVocabulary *BoolVocabulary
+
+	// TypeRegistry, if set, is used to decode maps into interface values
+	// other than the empty interface. If the map has the registry's
+	// discriminator key, a value of the registered type is decoded from
+	// the map and stored in the interface. The discriminator key itself
+	// is never reported as unused. Maps without the key are assigned as
+	// usual.
+	TypeRegistry *TypeRegistry
+
 	// Metadata is the struct that will contain extra metadata about
 	// the decoding. If this is nil, then no metadata will be tracked.
 	Metadata *Metadata
@@ -81,9 +152,257 @@ type DecoderConfig struct {
 	// value.
 	Result interface{}
 
+	// Squash, if set to true, squashes every embedded struct and pointer
+	// to a struct, as if it had the "squash" tag option. This promotes
+	// their fields the way encoding/json does. An embedded struct with a
+	// name in its tag is still decoded from a key of its own.
+	//
+	// Squashed fields with the same name then shadow each other as
+	// promoted fields do in Go: the least deeply squashed wins, then the
+	// one with a name in its tag, and otherwise neither is decoded.
+	// Without Squash, every field with the name is decoded from the key.
+	Squash bool
+
 	// The tag name that mapstructure reads for field names. This
 	// defaults to "mapstructure"
+	//
+	// After the name, the tag may list options separated by commas:
+	//
+	//   - squash: decode the fields of an embedded struct, or pointer to
+	//     a struct, as if they were fields of the parent. A nil pointer
+	//     is only allocated if one of its fields is in the data. See
+	//     Squash for fields with the same name.
+	//   - alias=names: other keys, separated by "|", that the field can
+	//     be decoded from. It is an error if more than one is present.
+	//   - deprecated: add a warning to Metadata when the field is decoded
+	//     from one of its aliases, or from its own key if it has none
+	//   - prefix=p: with squash, put p in front of the keys of the
+	//     squashed fields
+	//   - true=words, false=words: the words accepted for a bool,
+	//     separated by "|" (see BoolVocabulary)
+	//   - sep=s, trim: split a string on s (default ",") to decode it
+	//     into a slice or a map of "key=value" pairs, trimming white
+	//     space from the elements with trim. The elements are parsed as
+	//     numbers and bools as needed, without WeaklyTypedInput
+	//   - encoding=e: how a string is decoded into a []byte or [N]byte,
+	//     one of raw (the default), hex, base64, base64url, base64raw
+	//     and base64rawurl
+	//   - nil=b: what to do with nil data, one of ignore, zero and error
+	//     (see NilBehavior)
+	//   - merge=m: how to decode into a slice that has elements, one of
+	//     replace, append and index (see SliceMerge)
+	//   - pairs: decode a map from a list of key/value pairs (see
+	//     PairList)
+	//   - primary: decode a struct whose input isn't a map, such as a
+	//     string, into this field, leaving the other fields alone
+	//   - tuple: decode a struct from a list, assigning the elements to
+	//     the exported fields in order. This can also be set for a type
+	//     with a blank field: _ struct{} `mapstructure:",tuple"`
+	//   - index=n: the position of a field in a tuple
+	//   - mergekey=k: decode each element of a slice of structs or maps
+	//     into the existing element whose key k has the same value, and
+	//     append elements with new keys
+	//   - setter=m: with Setters, decode the field by calling the method
+	//     m with the value
+	//   - inline: the same as squash, as used by yaml
+	//   - omitempty: ignored, so that tags shared with encoding/json
+	//     can be read
+	//
+	// A field whose tag is "-" is not decoded.
 	TagName string
+
+	// Setters, if set to true, decodes into fields through setter
+	// methods. A key for an unexported field, such as maxConns, is decoded
+	// into the argument of a method SetMaxConns on a pointer to the
+	// struct, which is then called. The method must take one argument and
+	// return nothing or an error, which makes the decode fail. The
+	// "setter" tag option names the method to use instead, and also
+	// applies to exported fields.
+	Setters bool
+
+	// StrictTags, if set to true, makes NewDecoder check the tags of
+	// every struct reachable from the type of Result, and fail if one
+	// has an unknown option, an option with an invalid value, or options
+	// that conflict, such as squash with a name. Only the TagName tag is
+	// checked for unknown options, since other tags in TagNames, such as
+	// json, have options of their own.
+	StrictTags bool
+
+	// TagNames is the list of tags that mapstructure reads for field
+	// names, such as "mapstructure", "json" and "yaml". For each field,
+	// the first of these tags that is present is used for the name and
+	// options, and the others are ignored. If empty, only TagName is read.
+	TagNames []string
+}
+
+// WeakConversion is a set of flags that each enable one of the "weak"
+// conversions described for WeaklyTypedInput.
+type WeakConversion uint
+
+const (
+	// WeakBoolToString converts bools to string (true = "1", false = "0").
+	WeakBoolToString WeakConversion = 1 << iota
+
+	// WeakNumberToString converts numbers to string (base 10).
+	WeakNumberToString
+
+	// WeakBytesToString converts a []byte to string.
+	WeakBytesToString
+
+	// WeakBoolToNumber converts bools to numbers (true = 1, false = 0).
+	WeakBoolToNumber
+
+	// WeakStringToNumber parses strings as numbers (base implied by prefix).
+	WeakStringToNumber
+
+	// WeakNumberToBool converts numbers to bool (true if value != 0).
+	WeakNumberToBool
+
+	// WeakStringToBool parses strings as bools, see strconv.ParseBool.
+	// The empty string is false.
+	WeakStringToBool
+
+	// WeakEmptySliceToMap accepts an empty array or slice as an empty map.
+	WeakEmptySliceToMap
+
+	// WeakEmptyMapToSlice accepts an empty map as an empty slice.
+	WeakEmptyMapToSlice
+
+	// WeakNegativeToUint lets negative numbers overflow into uint values.
+	WeakNegativeToUint
+
+	// WeakSliceOfMapsToMap merges a slice of maps into a single map.
+	WeakSliceOfMapsToMap
+
+	// WeakAll enables every weak conversion. This is what
+	// WeaklyTypedInput uses.
+	WeakAll = WeakBoolToString | WeakNumberToString | WeakBytesToString |
+		WeakBoolToNumber | WeakStringToNumber | WeakNumberToBool |
+		WeakStringToBool | WeakEmptySliceToMap | WeakEmptyMapToSlice |
+		WeakNegativeToUint | WeakSliceOfMapsToMap
+)
+
+// PairList describes a list of key/value pairs that can be decoded into a
+// map. See PairList in DecoderConfig.
+type PairList struct {
+	// KeyField and ValueField are the keys holding the key and the value
+	// in a pair given as a map. They default to "key" and "value".
+	KeyField   string
+	ValueField string
+
+	// Separator splits a pair given as a string, such as "a=1", into the
+	// key and the value. It defaults to "=".
+	Separator string
+}
+
+// SliceMerge is a strategy for decoding into a slice that already has
+// elements. See SliceMerge in DecoderConfig.
+type SliceMerge int
+
+const (
+	// SliceReplace replaces the slice with the decoded elements. This is
+	// the default.
+	SliceReplace SliceMerge = iota
+
+	// SliceAppend appends the decoded elements to the slice.
+	SliceAppend
+
+	// SliceMergeIndex decodes each element into the existing element at
+	// the same index, keeping existing elements past the end of the data.
+	SliceMergeIndex
+)
+
+// parseSliceMerge parses the value of the "merge" tag option.
+func parseSliceMerge(s string) (SliceMerge, bool) {
+	switch s {
+	case "replace":
+		return SliceReplace, true
+	case "append":
+		return SliceAppend, true
+	case "index":
+		return SliceMergeIndex, true
+	default:
+		return SliceReplace, false
+	}
+}
+
+// NilBehavior is what the decoder does with nil data. See NilBehavior in
+// DecoderConfig.
+type NilBehavior int
+
+const (
+	// NilIgnore leaves the value untouched. This is the default.
+	NilIgnore NilBehavior = iota
+
+	// NilZero sets the value to its zero value, so pointers, maps,
+	// slices and interfaces become nil.
+	NilZero
+
+	// NilError makes the decode fail.
+	NilError
+)
+
+// parseNilBehavior parses the value of the "nil" tag option.
+func parseNilBehavior(s string) (NilBehavior, bool) {
+	switch s {
+	case "ignore":
+		return NilIgnore, true
+	case "zero":
+		return NilZero, true
+	case "error":
+		return NilError, true
+	default:
+		return NilIgnore, false
+	}
+}
+
+// BoolVocabulary is a set of words that are accepted as true and false
+// when decoding a string into a bool. Words are matched without regard
+// to case, using Unicode case folding rather than the rules of any
+// particular locale.
+type BoolVocabulary struct {
+	True  []string
+	False []string
+}
+
+// StandardBoolVocabulary returns the words accepted by strconv.ParseBool.
+func StandardBoolVocabulary() *BoolVocabulary {
+	return &BoolVocabulary{
+		True:  []string{"1", "t", "true"},
+		False: []string{"0", "f", "false"},
+	}
+}
+
+// ExtendedBoolVocabulary returns common operator-written words in
+// addition to the ones accepted by strconv.ParseBool.
+func ExtendedBoolVocabulary() *BoolVocabulary {
+	return &BoolVocabulary{
+		True:  []string{"1", "t", "true", "y", "yes", "on", "enable", "enabled"},
+		False: []string{"0", "f", "false", "n", "no", "off", "disable", "disabled"},
+	}
+}
+
+// Parse returns the bool for the given word and whether it was found.
+func (v *BoolVocabulary) Parse(s string) (bool, bool) {
+	for _, w := range v.True {
+		if strings.EqualFold(s, w) {
+			return true, true
+		}
+	}
+
+	for _, w := range v.False {
+		if strings.EqualFold(s, w) {
+			return false, true
+		}
+	}
+
+	return false, false
+}
+
+// String lists the accepted words, for use in error messages.
+func (v *BoolVocabulary) String() string {
+	return fmt.Sprintf("true: %s; false: %s",
+		strings.Join(v.True, ", "), strings.Join(v.False, ", "))
 }
 
 // A Decoder takes a raw interface value and turns it into structured
@@ -94,6 +413,13 @@ type DecoderConfig struct {
 // up the most basic Decoder.
 type Decoder struct {
 	config *DecoderConfig
+
+	// tag holds the options of the struct field being decoded, if any.
+	tag fieldTag
+
+	// discriminator is the TypeRegistry key that selected the type being
+	// decoded, if any, so that it isn't reported as unused.
+	discriminator string
 }
 
 // Metadata contains information about decoding a structure that
@@ -105,6 +431,10 @@ type Metadata struct {
 	// Unused is a slice of keys that were found in the raw value but
 	// weren't decoded since there was no matching field in the result interface
 	Unused []string
+
+	// Warnings are messages about keys that were decoded but should be
+	// changed, such as deprecated keys.
+	Warnings []string
 }
 
 // Decode takes a map and uses reflection to convert it into the
@@ -162,16 +492,35 @@ func NewDecoder(config *DecoderConfig) (*Decoder, error) {
 		if config.Metadata.Unused == nil {
 			config.Metadata.Unused = make([]string, 0)
 		}
+
+		if config.Metadata.Warnings == nil {
+			config.Metadata.Warnings = make([]string, 0)
+		}
 	}
 
 	if config.TagName == "" {
 		config.TagName = "mapstructure"
 	}
 
+	if len(config.TagNames) == 0 {
+		config.TagNames = []string{config.TagName}
+	}
+
+	if config.WeaklyTypedInput {
+		config.WeakConversions = WeakAll
+	}
+
 	result := &Decoder{
 		config: config,
 	}
 
+	if config.StrictTags {
+		errors := result.checkTags(val.Type(), make(map[reflect.Type]bool), make([]string, 0))
+		if len(errors) > 0 {
+			return nil, &Error{errors}
+		}
+	}
+
 	return result, nil
 }
 
@@ -181,32 +530,58 @@ func (d *Decoder) Decode(raw interface{}) error {
 	return d.decode("", raw, reflect.ValueOf(d.config.Result).Elem())
 }
 
+// field returns a decoder for the value of a struct field with the given
+// tag. The tag's options apply to the field's value, including the
+// elements of slices and maps, but not to the fields of nested structs.
+func (d *Decoder) field(tag fieldTag) *Decoder {
+	return &Decoder{config: d.config, tag: tag}
+}
+
+// weak reports whether the given weak conversion is enabled.
+func (d *Decoder) weak(c WeakConversion) bool {
+	return d.config.WeakConversions&c != 0
+}
+
 // Decodes an unknown data type into a specific reflection value.
 func (d *Decoder) decode(name string, data interface{}, val reflect.Value) error {
 
 	// fmt.Printf("name[%s] data[%v] val[%v] %s\n", name, data, val, dbgo.LF())
 
-	if data == nil {
-		// If the data is nil, then we don't set anything.
-		return nil
+	if val.Kind() == reflect.Struct && val.CanAddr() && reflect.PtrTo(val.Type()).Implements(optionalType) {
+		return d.decodeOptional(name, data, val.Addr().Interface().(optional))
 	}
 
-	dataVal := reflect.ValueOf(data)
-	if !dataVal.IsValid() {
-		// If the data value is invalid, then we just set the value to be the zero value.
-		val.Set(reflect.Zero(val.Type()))
-		return nil
+	if s, ok := data.(string); ok {
+		// Split the string if the field asks for it. This is done before
+		// the DecodeHook so that the field's separator wins over hooks
+		// such as StringToSliceHookFunc.
+		split, ok, err := d.splitString(name, s, val)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return d.splitElements().decode(name, split, val)
+		}
 	}
 
 	if d.config.DecodeHook != nil {
 		// We have a DecodeHook, so let's pre-process the data.
 		var err error
-		data, err = DecodeHookExec(d.config.DecodeHook, dataVal.Type(), val.Type(), data)
+		data, err = DecodeHookExec(d.config.DecodeHook, typeOf(data), val.Type(), data)
 		if err != nil {
 			return err
 		}
 	}
 
+	// The element of a singleton may itself be nil
+	if d.config.CoerceSingletons {
+		data = unwrapSingleton(data, val)
+	}
+
+	if data == nil {
+		return d.decodeNil(name, val)
+	}
+
 	var err error
 	dataKind := getKind(val)
 	switch dataKind {
@@ -216,7 +591,12 @@ func (d *Decoder) decode(name string, data interface{}, val reflect.Value) error
 		}
 		err = d.decodeBool(name, data, val)
 	case reflect.Interface:
-		err = d.decodeBasic(name, data, val)
+		var decoded bool
+		decoded, err = d.decodeInterface(name, data, val)
+		if decoded {
+			// The key was marked when the value was decoded
+			return err
+		}
 	case reflect.String:
 		err = d.decodeString(name, data, val)
 	case reflect.Int:
@@ -233,6 +613,8 @@ func (d *Decoder) decode(name string, data interface{}, val reflect.Value) error
 		err = d.decodePtr(name, data, val)
 	case reflect.Slice:
 		err = d.decodeSlice(name, data, val)
+	case reflect.Array:
+		err = d.decodeArray(name, data, val)
 	default:
 		// If we reached this point then we weren't able to decode it
 		return fmt.Errorf("%s: unsupported type: %s", name, dataKind)
@@ -247,6 +629,132 @@ func (d *Decoder) decode(name string, data interface{}, val reflect.Value) error
 	return err
 }
 
+// decodeOptional marks an Optional as present, and null if data is nil,
+// then decodes data into its Value.
+func (d *Decoder) decodeOptional(name string, data interface{}, opt optional) error {
+	value := opt.setPresent(data == nil)
+	if data == nil {
+		if d.config.Metadata != nil && name != "" {
+			d.config.Metadata.Keys = append(d.config.Metadata.Keys, name)
+		}
+
+		return nil
+	}
+
+	return d.decode(name, data, value)
+}
+
+// decodeNil handles nil data according to the NilBehavior, or the field's
+// "nil" tag option if it has one.
+func (d *Decoder) decodeNil(name string, val reflect.Value) error {
+	behavior := d.config.NilBehavior
+	if opt, ok := d.tag.option("nil"); ok {
+		var valid bool
+		behavior, valid = parseNilBehavior(opt)
+		if !valid {
+			return fmt.Errorf("'%s' has unknown nil option '%s'", name, opt)
+		}
+	}
+
+	switch behavior {
+	case NilZero:
+		val.Set(reflect.Zero(val.Type()))
+
+		if d.config.Metadata != nil && name != "" {
+			d.config.Metadata.Keys = append(d.config.Metadata.Keys, name)
+		}
+	case NilError:
+		return fmt.Errorf("'%s' must not be null", name)
+	}
+
+	return nil
+}
+
+// splitString splits s into a list, or a map of "key=value" pairs, if the
+// field has the "sep" or "trim" tag option and val is a slice or a map,
+// and reports whether it did. The separator defaults to "," and "trim"
+// removes surrounding white space from every element. The elements are
+// left as strings for the decoder from splitElements to convert.
+func (d *Decoder) splitString(name string, s string, val reflect.Value) (interface{}, bool, error) {
+	sep, hasSep := d.tag.option("sep")
+	trim := d.tag.has("trim")
+	if !hasSep && !trim {
+		return s, false, nil
+	}
+
+	if sep == "" {
+		sep = ","
+	}
+
+	var parts []string
+	if s != "" {
+		parts = strings.Split(s, sep)
+	}
+	if trim {
+		for i, p := range parts {
+			parts[i] = strings.TrimSpace(p)
+		}
+	}
+
+	switch val.Kind() {
+	case reflect.Array, reflect.Slice:
+		result := make([]interface{}, len(parts))
+		for i, p := range parts {
+			result[i] = p
+		}
+
+		return result, true, nil
+	case reflect.Map:
+		result := make(map[string]interface{}, len(parts))
+		for _, p := range parts {
+			k, v, ok := strings.Cut(p, "=")
+			if !ok {
+				return nil, false, fmt.Errorf("'%s' expected key=value, got '%s'", name, p)
+			}
+			if trim {
+				k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+			}
+
+			result[k] = v
+		}
+
+		return result, true, nil
+	default:
+		return s, false, nil
+	}
+}
+
+// splitElements returns a decoder for the elements of a string split by
+// splitString, or the value of a "key=value" pair. Since the elements are
+// all strings, it parses them as numbers and bools even without
+// WeaklyTypedInput, so that "1, 2, 3" can be decoded into a []int.
+func (d *Decoder) splitElements() *Decoder {
+	config := *d.config
+	config.WeakConversions |= WeakStringToNumber | WeakStringToBool
+	return &Decoder{config: &config, tag: d.tag, discriminator: d.discriminator}
+}
+
+// unwrapSingleton returns the element of a one-element list if val is a
+// bool, string or number, and data unchanged otherwise. Byte slices are
+// left alone so that they can still be decoded into strings.
+func unwrapSingleton(data interface{}, val reflect.Value) interface{} {
+	switch getKind(val) {
+	case reflect.Bool, reflect.String, reflect.Int, reflect.Uint, reflect.Float32:
+	default:
+		return data
+	}
+
+	dataVal := reflect.Indirect(reflect.ValueOf(data))
+	switch dataVal.Kind() {
+	case reflect.Array, reflect.Slice:
+		if dataVal.Len() == 1 && dataVal.Type().Elem().Kind() != reflect.Uint8 {
+			return dataVal.Index(0).Interface()
+		}
+	}
+
+	return data
+}
+
 // This decodes a basic type (bool, int, string, etc.) and sets the
 // value to "data" of that type.
 func (d *Decoder) decodeBasic(name string, data interface{}, val reflect.Value) error {
@@ -262,6 +770,69 @@ func (d *Decoder) decodeBasic(name string, data interface{}, val reflect.Value)
 	return nil
 }
 
+// This decodes into an interface value. If a TypeRegistry is configured
+// and data carries a registered discriminator, a value of the registered
+// type is decoded and stored. If the interface already holds a non-nil
+// pointer or a struct, data is decoded into that value. Otherwise data is
+// assigned directly. It reports whether data was decoded into another
+// value with decode, which has then marked the key in the Metadata.
+func (d *Decoder) decodeInterface(name string, data interface{}, val reflect.Value) (bool, error) {
+	valType := val.Type()
+	if d.config.TypeRegistry != nil && valType.NumMethod() > 0 {
+		typ, ok, err := d.config.TypeRegistry.lookup(name, data, valType)
+		if err != nil {
+			return false, err
+		}
+
+		if ok {
+			concrete := reflect.New(typ)
+			sub := &Decoder{
+				config:        d.config,
+				tag:           d.tag,
+				discriminator: d.config.TypeRegistry.key,
+			}
+			if err := sub.decode(name, data, concrete.Elem()); err != nil {
+				return true, err
+			}
+
+			if typ.Implements(valType) {
+				val.Set(concrete.Elem())
+			} else {
+				val.Set(concrete)
+			}
+
+			return true, nil
+		}
+	}
+
+	// Decode into the value the interface already holds, unless data
+	// can simply replace it.
+	if !val.IsNil() {
+		elem := val.Elem()
+		if !reflect.TypeOf(data).AssignableTo(elem.Type()) {
+			switch elem.Kind() {
+			case reflect.Ptr:
+				if !elem.IsNil() {
+					return true, d.decode(name, data, elem.Elem())
+				}
+			case reflect.Struct:
+				// The struct in the interface can't be set, so decode
+				// into a copy and store that.
+				current := reflect.New(elem.Type()).Elem()
+				current.Set(elem)
+				if err := d.decode(name, data, current); err != nil {
+					return true, err
+				}
+
+				val.Set(current)
+				return true, nil
+			}
+		}
+	}
+
+	return false, d.decodeBasic(name, data, val)
+}
+
 func (d *Decoder) decodeString(name string, data interface{}, val reflect.Value) error {
 	dataVal := reflect.ValueOf(data)
 	dataKind := getKind(dataVal)
@@ -270,19 +841,19 @@ func (d *Decoder) decodeString(name string, data interface{}, val reflect.Value)
 	switch {
 	case dataKind == reflect.String:
 		val.SetString(dataVal.String())
-	case dataKind == reflect.Bool && d.config.WeaklyTypedInput:
+	case dataKind == reflect.Bool && d.weak(WeakBoolToString):
 		if dataVal.Bool() {
 			val.SetString("1")
 		} else {
 			val.SetString("0")
 		}
-	case dataKind == reflect.Int && d.config.WeaklyTypedInput:
+	case dataKind == reflect.Int && d.weak(WeakNumberToString):
 		val.SetString(strconv.FormatInt(dataVal.Int(), 10))
-	case dataKind == reflect.Uint && d.config.WeaklyTypedInput:
+	case dataKind == reflect.Uint && d.weak(WeakNumberToString):
 		val.SetString(strconv.FormatUint(dataVal.Uint(), 10))
-	case dataKind == reflect.Float32 && d.config.WeaklyTypedInput:
+	case dataKind == reflect.Float32 && d.weak(WeakNumberToString):
 		val.SetString(strconv.FormatFloat(dataVal.Float(), 'f', -1, 64))
-	case dataKind == reflect.Slice && d.config.WeaklyTypedInput:
+	case dataKind == reflect.Slice && d.weak(WeakBytesToString):
 		dataType := dataVal.Type()
 		elemKind := dataType.Elem().Kind()
 		switch {
@@ -313,13 +884,13 @@ func (d *Decoder) decodeInt(name string, data interface{}, val reflect.Value) er
 		val.SetInt(int64(dataVal.Uint()))
 	case dataKind == reflect.Float32:
 		val.SetInt(int64(dataVal.Float()))
-	case dataKind == reflect.Bool && d.config.WeaklyTypedInput:
+	case dataKind == reflect.Bool && d.weak(WeakBoolToNumber):
 		if dataVal.Bool() {
 			val.SetInt(1)
 		} else {
 			val.SetInt(0)
 		}
-	case dataKind == reflect.String && d.config.WeaklyTypedInput:
+	case dataKind == reflect.String && d.weak(WeakStringToNumber):
 		i, err := strconv.ParseInt(dataVal.String(), 0, val.Type().Bits())
 		if err == nil {
 			val.SetInt(i)
@@ -340,7 +911,7 @@ func (d *Decoder) decodeUint(name string, data interface{}, val reflect.Value) e
 	switch {
 	case dataKind == reflect.Int:
 		i := dataVal.Int()
-		if i < 0 && !d.config.WeaklyTypedInput {
+		if i < 0 && !d.weak(WeakNegativeToUint) {
 			return fmt.Errorf("cannot parse '%s', %d overflows uint",
 				name, i)
 		}
@@ -349,18 +920,18 @@ func (d *Decoder) decodeUint(name string, data interface{}, val reflect.Value) e
 		val.SetUint(dataVal.Uint())
 	case dataKind == reflect.Float32:
 		f := dataVal.Float()
-		if f < 0 && !d.config.WeaklyTypedInput {
+		if f < 0 && !d.weak(WeakNegativeToUint) {
 			return fmt.Errorf("cannot parse '%s', %f overflows uint",
 				name, f)
 		}
 		val.SetUint(uint64(f))
-	case dataKind == reflect.Bool && d.config.WeaklyTypedInput:
+	case dataKind == reflect.Bool && d.weak(WeakBoolToNumber):
 		if dataVal.Bool() {
 			val.SetUint(1)
 		} else {
 			val.SetUint(0)
 		}
-	case dataKind == reflect.String && d.config.WeaklyTypedInput:
+	case dataKind == reflect.String && d.weak(WeakStringToNumber):
 		i, err := strconv.ParseUint(dataVal.String(), 0, val.Type().Bits())
 		if err == nil {
 			val.SetUint(i)
@@ -377,21 +948,30 @@ func (d *Decoder) decodeUint(name string, data interface{}, val reflect.Value) e
 func (d *Decoder) decodeBool(name string, data interface{}, val reflect.Value) error {
 	dataVal := reflect.ValueOf(data)
 	dataKind := getKind(dataVal)
+	vocab := d.boolVocabulary()
 
 	// fmt.Printf("decodeBool: name[%s], %v %v %s\n", name, dataVal, dataKind, dbgo.LF())
 
 	switch {
 	case dataKind == reflect.Bool:
 		val.SetBool(dataVal.Bool())
-	case dataKind == reflect.Int && d.config.WeaklyTypedInput:
+	case dataKind == reflect.Int && d.weak(WeakNumberToBool):
 		val.SetBool(dataVal.Int() != 0)
-	case dataKind == reflect.Uint && d.config.WeaklyTypedInput:
+	case dataKind == reflect.Uint && d.weak(WeakNumberToBool):
 		val.SetBool(dataVal.Uint() != 0)
-	case dataKind == reflect.Float32 && d.config.WeaklyTypedInput:
+	case dataKind == reflect.Float32 && d.weak(WeakNumberToBool):
 		val.SetBool(dataVal.Float() != 0)
-	case dataKind == reflect.Float64 && d.config.WeaklyTypedInput:
+	case dataKind == reflect.Float64 && d.weak(WeakNumberToBool):
 		val.SetBool(dataVal.Float() != 0)
-	case dataKind == reflect.String && d.config.WeaklyTypedInput:
+	case dataKind == reflect.String && vocab != nil:
+		if b, ok := vocab.Parse(dataVal.String()); ok {
+			val.SetBool(b)
+		} else if dataVal.String() == "" && d.weak(WeakStringToBool) {
+			val.SetBool(false)
+		} else {
+			return fmt.Errorf("cannot parse '%s' as bool: %q is not one of (%s)", name, dataVal.String(), vocab)
+		}
+	case dataKind == reflect.String && d.weak(WeakStringToBool):
 		b, err := strconv.ParseBool(dataVal.String())
 		if err == nil {
 			val.SetBool(b)
@@ -407,6 +987,32 @@ func (d *Decoder) decodeBool(name string, data interface{}, val reflect.Value) e
 	return nil
 }
 
+// boolVocabulary returns the words to use when decoding a string into a
+// bool, taking the field's "true" and "false" tag options into account.
+// It returns nil if strconv.ParseBool should be used.
+func (d *Decoder) boolVocabulary() *BoolVocabulary {
+	vocab := d.config.BoolVocabulary
+	trueWords, hasTrue := d.tag.optionList("true")
+	falseWords, hasFalse := d.tag.optionList("false")
+	if !hasTrue && !hasFalse {
+		return vocab
+	}
+
+	if vocab == nil {
+		vocab = StandardBoolVocabulary()
+	}
+
+	result := *vocab
+	if hasTrue {
+		result.True = trueWords
+	}
+	if hasFalse {
+		result.False = falseWords
+	}
+
+	return &result
+}
+
 func (d *Decoder) decodeFloat(name string, data interface{}, val reflect.Value) error {
 	dataVal := reflect.ValueOf(data)
 	dataKind := getKind(dataVal)
@@ -418,13 +1024,13 @@ func (d *Decoder) decodeFloat(name string, data interface{}, val reflect.Value)
 		val.SetFloat(float64(dataVal.Uint()))
 	case dataKind == reflect.Float32:
 		val.SetFloat(float64(dataVal.Float()))
-	case dataKind == reflect.Bool && d.config.WeaklyTypedInput:
+	case dataKind == reflect.Bool && d.weak(WeakBoolToNumber):
 		if dataVal.Bool() {
 			val.SetFloat(1)
 		} else {
 			val.SetFloat(0)
 		}
-	case dataKind == reflect.String && d.config.WeaklyTypedInput:
+	case dataKind == reflect.String && d.weak(WeakStringToNumber):
 		f, err := strconv.ParseFloat(dataVal.String(), val.Type().Bits())
 		if err == nil {
 			val.SetFloat(f)
@@ -456,16 +1062,27 @@ func (d *Decoder) decodeMap(name string, data interface{}, val reflect.Value) er
 	// Check input type
 	dataVal := reflect.Indirect(reflect.ValueOf(data))
 	if dataVal.Kind() != reflect.Map {
-		// In weak mode, we accept a slice of maps as an input...
-		if d.config.WeaklyTypedInput {
-			switch dataVal.Kind() {
-			case reflect.Array, reflect.Slice:
-				// Special case for BC reasons (covered by tests)
-				if dataVal.Len() == 0 {
-					val.Set(valMap)
-					return nil
-				}
+		switch dataVal.Kind() {
+		case reflect.Array, reflect.Slice:
+			// A list of key/value pairs, if enabled
+			if pairs := d.pairList(dataVal, valElemType); pairs != nil {
+				return d.decodePairs(name, dataVal, val, valMap, pairs)
+			}
+
+			// A list can be decoded into a set, unless it is a list of
+			// maps to merge
+			if isSetElem(valElemType) && !isListOfMaps(dataVal) {
+				return d.decodeSet(name, dataVal, val, valMap)
+			}
 
+			// In weak mode, we accept a slice of maps as an input...
+			// Special case for BC reasons (covered by tests)
+			if dataVal.Len() == 0 && d.weak(WeakEmptySliceToMap) {
+				val.Set(valMap)
+				return nil
+			}
+
+			if d.weak(WeakSliceOfMapsToMap) {
 				for i := 0; i < dataVal.Len(); i++ {
 					err := d.decode(
 						fmt.Sprintf("%s[%d]", name, i),
@@ -487,23 +1104,10 @@ func (d *Decoder) decodeMap(name string, data interface{}, val reflect.Value) er
 
 	for _, k := range dataVal.MapKeys() {
 		fieldName := fmt.Sprintf("%s[%s]", name, k)
-
-		// First decode the key into the proper type
-		currentKey := reflect.Indirect(reflect.New(valKeyType))
-		if err := d.decode(fieldName, k.Interface(), currentKey); err != nil {
-			errors = appendErrors(errors, err)
-			continue
-		}
-
-		// Next decode the data into the proper type
 		v := dataVal.MapIndex(k).Interface()
-		currentVal := reflect.Indirect(reflect.New(valElemType))
-		if err := d.decode(fieldName, v, currentVal); err != nil {
+		if err := d.decodeMapEntry(fieldName, k.Interface(), v, valMap); err != nil {
 			errors = appendErrors(errors, err)
-			continue
 		}
-
-		valMap.SetMapIndex(currentKey, currentVal)
 	}
 
 	// Set the built up map to the value
@@ -517,78 +1121,661 @@ func (d *Decoder) decodeMap(name string, data interface{}, val reflect.Value) er
 	return nil
 }
 
-func (d *Decoder) decodePtr(name string, data interface{}, val reflect.Value) error {
-	// Create an element of the concrete (non pointer) type and decode
-	// into that. Then set the value of the pointer to this type.
-	valType := val.Type()
-	valElemType := valType.Elem()
-	realVal := reflect.New(valElemType)
-	if err := d.decode(name, data, reflect.Indirect(realVal)); err != nil {
+// decodeMapEntry decodes a key and its value and stores them in valMap.
+// If the map already has a value for the key, the data is decoded into a
+// copy of it so that the value is merged rather than replaced.
+func (d *Decoder) decodeMapEntry(name string, key interface{}, data interface{}, valMap reflect.Value) error {
+	// First decode the key into the proper type
+	currentKey := reflect.Indirect(reflect.New(valMap.Type().Key()))
+	if err := d.decodeMapKey(name, key, currentKey); err != nil {
 		return err
 	}
 
-	val.Set(realVal)
+	// Next decode the data into the proper type
+	currentVal := reflect.Indirect(reflect.New(valMap.Type().Elem()))
+	if existing := valMap.MapIndex(currentKey); existing.IsValid() {
+		currentVal.Set(existing)
+	}
+	if err := d.decode(name, data, currentVal); err != nil {
+		return err
+	}
+
+	valMap.SetMapIndex(currentKey, currentVal)
 	return nil
 }
 
-func (d *Decoder) decodeSlice(name string, data interface{}, val reflect.Value) error {
-	dataVal := reflect.Indirect(reflect.ValueOf(data))
-	dataValKind := dataVal.Kind()
-	valType := val.Type()
-	valElemType := valType.Elem()
-	sliceType := reflect.SliceOf(valElemType)
-
-	// Check input type
-	if dataValKind != reflect.Array && dataValKind != reflect.Slice {
-		// Accept empty map instead of array/slice in weakly typed mode
-		if d.config.WeaklyTypedInput && dataVal.Kind() == reflect.Map && dataVal.Len() == 0 {
-			val.Set(reflect.MakeSlice(sliceType, 0, 0))
-			return nil
-		} else {
-			return fmt.Errorf(
-				"'%s': source data must be an array or slice, got %s", name, dataValKind)
+// pairList returns how to decode the list dataVal into a map with values
+// of type elemType as key/value pairs, or nil if that isn't enabled for
+// the value being decoded.
+func (d *Decoder) pairList(dataVal reflect.Value, elemType reflect.Type) *PairList {
+	if d.tag.has("pairs") {
+		if d.config.PairList != nil {
+			return d.config.PairList
 		}
+		return &PairList{}
 	}
 
-	// Make a new slice to hold our result, same size as the original data.
-	valSlice := reflect.MakeSlice(sliceType, dataVal.Len(), dataVal.Len())
-
-	// Accumulate any errors
-	errors := make([]string, 0)
+	pairs := d.config.PairList
+	if pairs == nil || isSetElem(elemType) {
+		return nil
+	}
 
+	keyField, _, sep := pairs.fields()
 	for i := 0; i < dataVal.Len(); i++ {
-		currentData := dataVal.Index(i).Interface()
-		currentField := valSlice.Index(i)
-
-		fieldName := fmt.Sprintf("%s[%d]", name, i)
-		if err := d.decode(fieldName, currentData, currentField); err != nil {
-			errors = appendErrors(errors, err)
+		pair := reflect.Indirect(reflect.ValueOf(dataVal.Index(i).Interface()))
+		switch pair.Kind() {
+		case reflect.String:
+			if !strings.Contains(pair.String(), sep) {
+				return nil
+			}
+		case reflect.Map:
+			if !mapValueByKey(pair, keyField).IsValid() {
+				return nil
+			}
+		default:
+			return nil
 		}
 	}
 
-	// Finally, set the value to the slice we built up
-	val.Set(valSlice)
+	return pairs
+}
 
-	// If there were errors, we return those
-	if len(errors) > 0 {
-		return &Error{errors}
+// fields returns the KeyField, ValueField and Separator of the pairs,
+// with their defaults.
+func (p *PairList) fields() (string, string, string) {
+	keyField, valueField, sep := p.KeyField, p.ValueField, p.Separator
+	if keyField == "" {
+		keyField = "key"
+	}
+	if valueField == "" {
+		valueField = "value"
+	}
+	if sep == "" {
+		sep = "="
 	}
 
-	return nil
+	return keyField, valueField, sep
 }
 
-func (d *Decoder) decodeStruct(name string, data interface{}, val reflect.Value) error {
-	dataVal := reflect.Indirect(reflect.ValueOf(data))
+// decodePairs decodes a list of key/value pairs into valMap and sets val
+// to it. Each pair is a map holding the key and the value, or a string
+// holding both around a separator.
+func (d *Decoder) decodePairs(name string, dataVal reflect.Value, val reflect.Value, valMap reflect.Value, pairs *PairList) error {
+	keyField, valueField, sep := pairs.fields()
 
-	// If the type of the value to write to and the data match directly,
-	// then we just set it directly instead of recursing into the structure.
-	if dataVal.Type() == val.Type() {
+	// Accumulate errors
+	errors := make([]string, 0)
+
+	for i := 0; i < dataVal.Len(); i++ {
+		pairName := fmt.Sprintf("%s[%d]", name, i)
+
+		var key, value interface{}
+		decoder := d
+		pair := reflect.Indirect(reflect.ValueOf(dataVal.Index(i).Interface()))
+		switch pair.Kind() {
+		case reflect.String:
+			k, v, ok := strings.Cut(pair.String(), sep)
+			if !ok {
+				errors = appendErrors(errors,
+					fmt.Errorf("'%s' expected key%svalue, got '%s'", pairName, sep, pair.String()))
+				continue
+			}
+
+			// The value was split out of a string too
+			key, value = k, v
+			decoder = d.splitElements()
+		case reflect.Map:
+			k := mapValueByKey(pair, keyField)
+			if !k.IsValid() {
+				errors = appendErrors(errors,
+					fmt.Errorf("'%s' is missing the key '%s'", pairName, keyField))
+				continue
+			}
+
+			key = k.Interface()
+			if v := mapValueByKey(pair, valueField); v.IsValid() {
+				value = v.Interface()
+			}
+		default:
+			errors = appendErrors(errors,
+				fmt.Errorf("'%s' expected a key/value pair, got '%s'", pairName, pair.Kind()))
+			continue
+		}
+
+		fieldName := fmt.Sprintf("%s[%v]", name, key)
+		if err := decoder.decodeMapEntry(fieldName, key, value, valMap); err != nil {
+			errors = appendErrors(errors, err)
+		}
+	}
+
+	// Set the built up map to the value
+	val.Set(valMap)
+
+	// If we had errors, return those
+	if len(errors) > 0 {
+		return &Error{errors}
+	}
+
+	return nil
+}
+
+// decodeSet decodes a list into valMap, a set whose values are struct{} or
+// bool, and sets val to it. Every element of the list is decoded as a key,
+// with true as the value in a map of bools.
+func (d *Decoder) decodeSet(name string, dataVal reflect.Value, val reflect.Value, valMap reflect.Value) error {
+	valKeyType := valMap.Type().Key()
+	present := reflect.New(valMap.Type().Elem()).Elem()
+	if present.Kind() == reflect.Bool {
+		present.SetBool(true)
+	}
+
+	// Accumulate errors
+	errors := make([]string, 0)
+
+	seen := make(map[interface{}]struct{})
+	for i := 0; i < dataVal.Len(); i++ {
+		fieldName := fmt.Sprintf("%s[%d]", name, i)
+
+		currentKey := reflect.Indirect(reflect.New(valKeyType))
+		if err := d.decodeMapKey(fieldName, dataVal.Index(i).Interface(), currentKey); err != nil {
+			errors = appendErrors(errors, err)
+			continue
+		}
+
+		if _, ok := seen[currentKey.Interface()]; ok && d.config.ErrorDuplicates {
+			errors = appendErrors(errors,
+				fmt.Errorf("'%s' has duplicate element '%v'", fieldName, currentKey.Interface()))
+			continue
+		}
+		seen[currentKey.Interface()] = struct{}{}
+
+		valMap.SetMapIndex(currentKey, present)
+	}
+
+	// Set the built up map to the value
+	val.Set(valMap)
+
+	// If we had errors, return those
+	if len(errors) > 0 {
+		return &Error{errors}
+	}
+
+	return nil
+}
+
+// isSetElem reports whether a map with values of type t is a set.
+func isSetElem(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool:
+		return true
+	case reflect.Struct:
+		return t.NumField() == 0
+	default:
+		return false
+	}
+}
+
+// isListOfMaps reports whether the list dataVal has an element that is a
+// map.
+func isListOfMaps(dataVal reflect.Value) bool {
+	for i := 0; i < dataVal.Len(); i++ {
+		elem := reflect.Indirect(reflect.ValueOf(dataVal.Index(i).Interface()))
+		if elem.Kind() == reflect.Map {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decodeMapKey decodes a map key into val. Since formats such as JSON and
+// YAML only have string keys, a string key is decoded with the key type's
+// encoding.TextUnmarshaler if it has one, or parsed if the key type is a
+// number or bool, whether or not weak conversions are enabled. Other keys
+// are decoded as usual. The DecodeHook runs first in every case.
+func (d *Decoder) decodeMapKey(name string, key interface{}, val reflect.Value) error {
+	keyDecoder := d
+	if d.config.DecodeHook != nil {
+		var err error
+		key, err = DecodeHookExec(d.config.DecodeHook, typeOf(key), val.Type(), key)
+		if err != nil {
+			return err
+		}
+
+		// The hook has already run, so don't run it again
+		keyConfig := *d.config
+		keyConfig.DecodeHook = nil
+		keyDecoder = &Decoder{config: &keyConfig}
+	}
+
+	keyVal := reflect.ValueOf(key)
+	if keyVal.Kind() != reflect.String {
+		return keyDecoder.decode(name, key, val)
+	}
+
+	s := keyVal.String()
+	if u, ok := val.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(s)); err != nil {
+			return fmt.Errorf("cannot parse key '%s' as %s: %s", name, val.Type(), err)
+		}
+
+		return nil
+	}
+
+	var err error
+	switch getKind(val) {
+	case reflect.Int:
+		var i int64
+		if i, err = strconv.ParseInt(s, 0, val.Type().Bits()); err == nil {
+			val.SetInt(i)
+		}
+	case reflect.Uint:
+		var i uint64
+		if i, err = strconv.ParseUint(s, 0, val.Type().Bits()); err == nil {
+			val.SetUint(i)
+		}
+	case reflect.Float32:
+		var f float64
+		if f, err = strconv.ParseFloat(s, val.Type().Bits()); err == nil {
+			val.SetFloat(f)
+		}
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(s); err == nil {
+			val.SetBool(b)
+		}
+	default:
+		return keyDecoder.decode(name, key, val)
+	}
+
+	if err != nil {
+		return fmt.Errorf("cannot parse key '%s' as %s: %s", name, val.Type(), err)
+	}
+
+	return nil
+}
+
+func (d *Decoder) decodePtr(name string, data interface{}, val reflect.Value) error {
+	// If the pointer is already set, decode into what it points to so
+	// that existing values are merged, unless we're zeroing fields.
+	if !val.IsNil() && !d.config.ZeroFields {
+		return d.decode(name, data, val.Elem())
+	}
+
+	// Create an element of the concrete (non pointer) type and decode
+	// into that. Then set the value of the pointer to this type.
+	valType := val.Type()
+	valElemType := valType.Elem()
+	realVal := reflect.New(valElemType)
+	if err := d.decode(name, data, reflect.Indirect(realVal)); err != nil {
+		return err
+	}
+
+	val.Set(realVal)
+	return nil
+}
+
+func (d *Decoder) decodeSlice(name string, data interface{}, val reflect.Value) error {
+	dataVal := reflect.Indirect(reflect.ValueOf(data))
+	dataValKind := dataVal.Kind()
+	valType := val.Type()
+	valElemType := valType.Elem()
+	sliceType := reflect.SliceOf(valElemType)
+
+	// Strings are decoded into byte slices using the field's encoding
+	if valElemType.Kind() == reflect.Uint8 && dataValKind == reflect.String {
+		b, err := d.decodeEncodedString(name, dataVal.String())
+		if err != nil {
+			return err
+		}
+
+		valSlice := reflect.MakeSlice(valType, len(b), len(b))
+		setBytes(valSlice, b)
+		val.Set(valSlice)
+		return nil
+	}
+
+	// Check input type
+	if dataValKind != reflect.Array && dataValKind != reflect.Slice {
+		// Accept empty map instead of array/slice in weakly typed mode
+		if d.weak(WeakEmptyMapToSlice) && dataVal.Kind() == reflect.Map && dataVal.Len() == 0 {
+			val.Set(reflect.MakeSlice(sliceType, 0, 0))
+			return nil
+		} else if d.config.CoerceSingletons {
+			// Treat the single value as a list of one element
+			dataVal = reflect.ValueOf([]interface{}{data})
+		} else {
+			return fmt.Errorf(
+				"'%s': source data must be an array or slice, got %s", name, dataValKind)
+		}
+	}
+
+	strategy, mergeKey, err := d.sliceMerge(name)
+	if err != nil {
+		return err
+	}
+
+	// Existing elements are only kept if we aren't zeroing fields
+	existing := val
+	if d.config.ZeroFields {
+		existing = reflect.MakeSlice(sliceType, 0, 0)
+	}
+
+	// Make a new slice to hold our result. Depending on the strategy it
+	// starts out with copies of the existing elements, and the element
+	// for each item of the data is found by index.
+	var valSlice reflect.Value
+	var index func(i int) int
+	switch {
+	case mergeKey != "":
+		valSlice = reflect.MakeSlice(sliceType, existing.Len(), existing.Len()+dataVal.Len())
+		reflect.Copy(valSlice, existing)
+		index = func(i int) int {
+			if j := d.indexByMergeKey(valSlice, mergeKey, dataVal.Index(i)); j >= 0 {
+				return j
+			}
+
+			valSlice = reflect.Append(valSlice, reflect.Zero(valElemType))
+			return valSlice.Len() - 1
+		}
+	case strategy == SliceAppend:
+		valSlice = reflect.MakeSlice(sliceType, existing.Len()+dataVal.Len(), existing.Len()+dataVal.Len())
+		reflect.Copy(valSlice, existing)
+		index = func(i int) int { return existing.Len() + i }
+	case strategy == SliceMergeIndex:
+		length := existing.Len()
+		if dataVal.Len() > length {
+			length = dataVal.Len()
+		}
+
+		valSlice = reflect.MakeSlice(sliceType, length, length)
+		reflect.Copy(valSlice, existing)
+		index = func(i int) int { return i }
+	default:
+		// Same size as the original data.
+		valSlice = reflect.MakeSlice(sliceType, dataVal.Len(), dataVal.Len())
+		index = func(i int) int { return i }
+	}
+
+	// Accumulate any errors
+	errors := make([]string, 0)
+
+	for i := 0; i < dataVal.Len(); i++ {
+		currentData := dataVal.Index(i).Interface()
+		j := index(i)
+		currentField := valSlice.Index(j)
+
+		fieldName := fmt.Sprintf("%s[%d]", name, i)
+		if err := d.decode(fieldName, currentData, currentField); err != nil {
+			errors = appendErrors(errors, err)
+		}
+	}
+
+	// Finally, set the value to the slice we built up
+	val.Set(valSlice)
+
+	// If there were errors, we return those
+	if len(errors) > 0 {
+		return &Error{errors}
+	}
+
+	return nil
+}
+
+// sliceMerge returns the slice merge strategy for the value being decoded
+// and, if elements are merged by key, the name of the key.
+func (d *Decoder) sliceMerge(name string) (SliceMerge, string, error) {
+	if key, ok := d.tag.option("mergekey"); ok {
+		if key == "" {
+			return SliceReplace, "", fmt.Errorf("'%s' has an empty mergekey option", name)
+		}
+
+		return SliceReplace, key, nil
+	}
+
+	if opt, ok := d.tag.option("merge"); ok {
+		strategy, valid := parseSliceMerge(opt)
+		if !valid {
+			return SliceReplace, "", fmt.Errorf("'%s' has unknown merge option '%s'", name, opt)
+		}
+
+		return strategy, "", nil
+	}
+
+	return d.config.SliceMerge, "", nil
+}
+
+// indexByMergeKey returns the index of the element of slice whose key
+// matches the key of the given data, or -1 if there is none. Elements
+// are structs, maps or pointers to them, and the key of the data is
+// decoded into the type of the element's key before comparing.
+func (d *Decoder) indexByMergeKey(slice reflect.Value, key string, data reflect.Value) int {
+	dataKey := mapValueByKey(reflect.Indirect(reflect.ValueOf(data.Interface())), key)
+	if !dataKey.IsValid() {
+		return -1
+	}
+
+	for i := 0; i < slice.Len(); i++ {
+		elemKey := d.valueByKey(slice.Index(i), key)
+		if !elemKey.IsValid() {
+			continue
+		}
+
+		decoded := reflect.New(elemKey.Type()).Elem()
+		keyDecoder := &Decoder{config: d.config}
+		if err := keyDecoder.decode("", dataKey.Interface(), decoded); err != nil {
+			continue
+		}
+
+		if reflect.DeepEqual(decoded.Interface(), elemKey.Interface()) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// valueByKey returns the struct field or map value of v for the given
+// key, matching struct fields by tag or name without regard to case.
+// It returns the zero Value if there is no such field or key.
+func (d *Decoder) valueByKey(v reflect.Value, key string) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			fieldType := v.Type().Field(i)
+			tag := d.structTag(fieldType)
+			if tag.Skip {
+				continue
+			}
+
+			fieldName := fieldType.Name
+			if tag.Name != "" {
+				fieldName = tag.Name
+			}
+
+			if strings.EqualFold(fieldName, key) {
+				return v.Field(i)
+			}
+		}
+	case reflect.Map:
+		return mapValueByKey(v, key)
+	}
+
+	return reflect.Value{}
+}
+
+// mapValueByKey returns the value of the string key in the map m, falling
+// back to a case-insensitive match. It returns the zero Value if m isn't
+// a map with string keys or doesn't have the key.
+func mapValueByKey(m reflect.Value, key string) reflect.Value {
+	if m.Kind() != reflect.Map {
+		return reflect.Value{}
+	}
+	if kind := m.Type().Key().Kind(); kind != reflect.String && kind != reflect.Interface {
+		return reflect.Value{}
+	}
+
+	k := reflect.ValueOf(key)
+	if m.Type().Key().Kind() == reflect.String {
+		k = k.Convert(m.Type().Key())
+	}
+	if v := m.MapIndex(k); v.IsValid() {
+		return v
+	}
+
+	for _, mapKey := range m.MapKeys() {
+		mK := mapKey
+		if mK.Kind() == reflect.Interface {
+			mK = mK.Elem()
+		}
+
+		if mK.Kind() == reflect.String && strings.EqualFold(mK.String(), key) {
+			return m.MapIndex(mapKey)
+		}
+	}
+
+	return reflect.Value{}
+}
+
+func (d *Decoder) decodeArray(name string, data interface{}, val reflect.Value) error {
+	dataVal := reflect.Indirect(reflect.ValueOf(data))
+	dataValKind := dataVal.Kind()
+	valType := val.Type()
+
+	// Strings are decoded into byte arrays using the field's encoding,
+	// and must fill the array exactly.
+	if valType.Elem().Kind() == reflect.Uint8 && dataValKind == reflect.String {
+		b, err := d.decodeEncodedString(name, dataVal.String())
+		if err != nil {
+			return err
+		}
+
+		if len(b) != val.Len() {
+			return fmt.Errorf("'%s' expected %d bytes, got %d", name, val.Len(), len(b))
+		}
+
+		setBytes(val, b)
+		return nil
+	}
+
+	// Check input type
+	if dataValKind != reflect.Array && dataValKind != reflect.Slice {
+		// Accept empty map instead of array/slice in weakly typed mode
+		if d.weak(WeakEmptyMapToSlice) && dataVal.Kind() == reflect.Map && dataVal.Len() == 0 {
+			val.Set(reflect.Zero(valType))
+			return nil
+		} else if d.config.CoerceSingletons {
+			// Treat the single value as a list of one element
+			dataVal = reflect.ValueOf([]interface{}{data})
+		} else {
+			return fmt.Errorf(
+				"'%s': source data must be an array or slice, got %s", name, dataValKind)
+		}
+	}
+
+	if dataVal.Len() > val.Len() {
+		return fmt.Errorf(
+			"'%s': expected source data to have length less or equal to %d, got %d",
+			name, val.Len(), dataVal.Len())
+	}
+
+	// Make a new array to hold our result
+	valArray := reflect.New(valType).Elem()
+
+	// Accumulate any errors
+	errors := make([]string, 0)
+
+	for i := 0; i < dataVal.Len(); i++ {
+		currentData := dataVal.Index(i).Interface()
+		currentField := valArray.Index(i)
+
+		fieldName := fmt.Sprintf("%s[%d]", name, i)
+		if err := d.decode(fieldName, currentData, currentField); err != nil {
+			errors = appendErrors(errors, err)
+		}
+	}
+
+	// Finally, set the value to the array we built up
+	val.Set(valArray)
+
+	// If there were errors, we return those
+	if len(errors) > 0 {
+		return &Error{errors}
+	}
+
+	return nil
+}
+
+// decodeEncodedString decodes s into bytes using the encoding given by
+// the field's "encoding" tag option: "raw" (the default), "hex",
+// "base64", "base64url", "base64raw" or "base64rawurl". The last two
+// are base64 without padding.
+func (d *Decoder) decodeEncodedString(name string, s string) ([]byte, error) {
+	encoding, _ := d.tag.option("encoding")
+
+	var b []byte
+	var err error
+	switch encoding {
+	case "", "raw":
+		return []byte(s), nil
+	case "hex":
+		b, err = hex.DecodeString(s)
+	case "base64":
+		b, err = base64.StdEncoding.DecodeString(s)
+	case "base64url":
+		b, err = base64.URLEncoding.DecodeString(s)
+	case "base64raw":
+		b, err = base64.RawStdEncoding.DecodeString(s)
+	case "base64rawurl":
+		b, err = base64.RawURLEncoding.DecodeString(s)
+	default:
+		return nil, fmt.Errorf("'%s' has unknown encoding '%s'", name, encoding)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode '%s' as %s: %s", name, encoding, err)
+	}
+
+	return b, nil
+}
+
+// setBytes copies b into val, which must be a slice or array of at least
+// len(b) bytes. The element type may be any type with kind uint8.
+func setBytes(val reflect.Value, b []byte) {
+	for i, c := range b {
+		val.Index(i).SetUint(uint64(c))
+	}
+}
+
+func (d *Decoder) decodeStruct(name string, data interface{}, val reflect.Value) error {
+	dataVal := reflect.Indirect(reflect.ValueOf(data))
+
+	// If the type of the value to write to and the data match directly,
+	// then we just set it directly instead of recursing into the structure.
+	if dataVal.Type() == val.Type() {
 		val.Set(dataVal)
 		return nil
 	}
 
 	dataValKind := dataVal.Kind()
+	if (dataValKind == reflect.Array || dataValKind == reflect.Slice) && d.isTuple(val.Type()) {
+		return d.decodeTuple(name, dataVal, val)
+	}
+
 	if dataValKind != reflect.Map {
+		// A struct with a primary field can be written as just the value
+		// of that field.
+		if i, ok := d.primaryField(val.Type()); ok {
+			return d.decodePrimary(name, data, val, i)
+		}
+
 		return fmt.Errorf("'%s' expected a map, got '%s'", name, dataValKind)
 	}
 
@@ -606,116 +1793,196 @@ func (d *Decoder) decodeStruct(name string, data interface{}, val reflect.Value)
 		dataValKeysUnused[dataValKey.Interface()] = struct{}{}
 	}
 
+	// The key that chose this type from a TypeRegistry has been used
+	if d.discriminator != "" {
+		delete(dataValKeysUnused, reflect.ValueOf(d.discriminator).Convert(dataValType.Key()).Interface())
+	}
+
 	errors := make([]string, 0)
 
 	// This slice will keep track of all the structs we'll be decoding.
 	// There can be more than one struct if there are embedded structs
-	// that are squashed.
-	structs := make([]reflect.Value, 1, 5)
-	structs[0] = val
+	// that are squashed, which are found level by level.
+	structs := make([]squashedStruct, 1, 5)
+	structs[0] = squashedStruct{typ: val.Type()}
+
+	// With the Squash option, a struct that is squashed again deeper
+	// down with the same prefix is skipped, since its fields are all
+	// shadowed. This holds the depth each struct was first seen at.
+	type visit struct {
+		typ    reflect.Type
+		prefix string
+	}
+	visited := make(map[visit]int)
 
 	// Compile the list of all the fields that we're going to be decoding
 	// from all the structs.
-	fields := make(map[*reflect.StructField]reflect.Value)
+	fields := make([]structField, 0, val.NumField())
 	for len(structs) > 0 {
-		structVal := structs[0]
+		structType := structs[0].typ
+		index := structs[0].index
+		depth := structs[0].depth
+		prefix := structs[0].prefix
 		structs = structs[1:]
 
-		structType := structVal.Type()
+		if d.config.Squash {
+			key := visit{structType, prefix}
+			if seen, ok := visited[key]; ok && seen < depth {
+				continue
+			}
+			visited[key] = depth
+		} else if squashCycle(val.Type(), index) {
+			// A struct that squashes a pointer to itself
+			continue
+		}
 
 		for i := 0; i < structType.NumField(); i++ {
 			fieldType := structType.Field(i)
 			fieldKind := fieldType.Type.Kind()
 
-			if fieldType.Anonymous {
-				if fieldKind != reflect.Struct {
-					errors = appendErrors(errors,
-						fmt.Errorf("%s: unsupported type: %s", fieldType.Name, fieldKind))
-					continue
-				}
+			// If "squash" is specified in the tag, we squash the field down.
+			// With the Squash option, embedded structs without a name in
+			// their tag are squashed too.
+			tag := d.structTag(fieldType)
+			if tag.Skip {
+				continue
 			}
 
-			// If "squash" is specified in the tag, we squash the field down.
-			squash := false
-			tagParts := strings.Split(fieldType.Tag.Get(d.config.TagName), ",")
-			for _, tag := range tagParts[1:] {
-				if tag == "squash" {
-					squash = true
-					break
-				}
+			squash := tag.has("squash")
+			if d.config.Squash && fieldType.Anonymous && tag.Name == "" {
+				squash = squash || fieldKind == reflect.Struct ||
+					fieldKind == reflect.Ptr && fieldType.Type.Elem().Kind() == reflect.Struct
 			}
 
 			if squash {
-				if fieldKind != reflect.Struct {
+				// The keys of the squashed fields can have a prefix
+				squashPrefix, _ := tag.option("prefix")
+				squashPrefix = prefix + squashPrefix
+
+				switch {
+				case fieldKind == reflect.Struct:
+					structs = append(structs, squashedStruct{fieldType.Type, appendIndex(index, i), depth + 1, squashPrefix})
+				case fieldKind == reflect.Ptr && fieldType.Type.Elem().Kind() == reflect.Struct:
+					structs = append(structs, squashedStruct{fieldType.Type.Elem(), appendIndex(index, i), depth + 1, squashPrefix})
+				default:
 					errors = appendErrors(errors,
 						fmt.Errorf("%s: unsupported type for squash: %s", fieldType.Name, fieldKind))
-				} else {
-					structs = append(structs, val.FieldByName(fieldType.Name))
 				}
 				continue
 			}
 
-			// Normal struct field, store it away
-			fields[&fieldType] = structVal.Field(i)
+			// Normal struct field, store it away. This includes embedded
+			// fields that aren't squashed, which are named after their type.
+			fieldName := fieldType.Name
+			if tag.Name != "" {
+				fieldName = tag.Name
+			}
+
+			aliases, _ := tag.optionList("alias")
+			for i := range aliases {
+				aliases[i] = prefix + aliases[i]
+			}
+
+			fields = append(fields, structField{
+				name:    prefix + fieldName,
+				aliases: aliases,
+				tag:     tag,
+				index:   appendIndex(index, i),
+				depth:   depth,
+			})
 		}
 	}
 
-	for fieldType, field := range fields {
-		fieldName := fieldType.Name
+	if d.config.Squash {
+		fields = dominantFields(fields)
+	}
+
+	for _, f := range fields {
+		fieldName := f.name
+		tag := f.tag
 
-		tagValue := fieldType.Tag.Get(d.config.TagName)
-		tagValue = strings.SplitN(tagValue, ",", 2)[0]
-		if tagValue != "" {
-			fieldName = tagValue
-		}
+		rawMapKey, rawMapVal := findMapKey(dataVal, dataValKeys, fieldName)
 
-		rawMapKey := reflect.ValueOf(fieldName)
-		rawMapVal := dataVal.MapIndex(rawMapKey)
-		if !rawMapVal.IsValid() {
-			// Do a slower search by iterating over each key and
-			// doing case-insensitive search.
-			for dataValKey, _ := range dataValKeys {
-				mK, ok := dataValKey.Interface().(string)
-				if !ok {
-					// Not a string key
-					continue
-				}
+		// The field can also be decoded from one of its aliases, but not
+		// from more than one key.
+		conflict := false
+		for _, alias := range f.aliases {
+			aliasKey, aliasVal := findMapKey(dataVal, dataValKeys, alias)
+			if !aliasVal.IsValid() {
+				continue
+			}
 
-				if strings.EqualFold(mK, fieldName) {
-					rawMapKey = dataValKey
-					rawMapVal = dataVal.MapIndex(dataValKey)
-					break
-				}
+			// Names that differ only by case find the same key
+			if rawMapVal.IsValid() && aliasKey.Interface() == rawMapKey.Interface() {
+				continue
 			}
 
-			if !rawMapVal.IsValid() {
-				// There was no matching key in the map for the value in
-				// the struct. Just ignore.
+			delete(dataValKeysUnused, aliasKey.Interface())
+			if rawMapVal.IsValid() {
+				errors = appendErrors(errors, fmt.Errorf("'%s' and '%s' are both set",
+					joinName(name, fieldName), joinName(name, alias)))
+				conflict = true
 				continue
 			}
+
+			if tag.has("deprecated") && d.config.Metadata != nil {
+				d.config.Metadata.Warnings = append(d.config.Metadata.Warnings,
+					fmt.Sprintf("'%s' is deprecated, use '%s' instead",
+						joinName(name, alias), joinName(name, f.name)))
+			}
+
+			rawMapKey, rawMapVal = aliasKey, aliasVal
+			fieldName = alias
+		}
+
+		if !rawMapVal.IsValid() {
+			// There was no matching key in the map for the value in
+			// the struct. Just ignore.
+			continue
 		}
 
 		// Delete the key we're using from the unused map so we stop tracking
 		delete(dataValKeysUnused, rawMapKey.Interface())
 
-		if !field.IsValid() {
-			// This should never happen
-			panic("field is not valid")
+		if conflict {
+			continue
+		}
+
+		if tag.has("deprecated") && len(f.aliases) == 0 && d.config.Metadata != nil {
+			d.config.Metadata.Warnings = append(d.config.Metadata.Warnings,
+				fmt.Sprintf("'%s' is deprecated", joinName(name, fieldName)))
+		}
+
+		fieldName = joinName(name, fieldName)
+
+		// With Setters, the field may be set by calling a method
+		if d.config.Setters {
+			method, err := setter(val, f)
+			if err != nil {
+				errors = appendErrors(errors, fmt.Errorf("'%s' %s", fieldName, err))
+				continue
+			}
+
+			if method.IsValid() {
+				if err := d.field(tag).callSetter(fieldName, rawMapVal.Interface(), method); err != nil {
+					errors = appendErrors(errors, err)
+				}
+				continue
+			}
 		}
 
 		// If we can't set the field, then it is unexported or something,
 		// and we just continue onwards.
-		if !field.CanSet() {
+		field, err := fieldByIndex(val, f.index)
+		if err != nil {
+			errors = appendErrors(errors, fmt.Errorf("'%s' %s", fieldName, err))
 			continue
 		}
-
-		// If the name is empty string, then we're at the root, and we
-		// don't dot-join the fields.
-		if name != "" {
-			fieldName = fmt.Sprintf("%s.%s", name, fieldName)
+		if !field.IsValid() {
+			continue
 		}
 
-		if err := d.decode(fieldName, rawMapVal.Interface(), field); err != nil {
+		if err := d.field(tag).decode(fieldName, rawMapVal.Interface(), field); err != nil {
 			errors = appendErrors(errors, err)
 		}
 	}
@@ -723,7 +1990,7 @@ func (d *Decoder) decodeStruct(name string, data interface{}, val reflect.Value)
 	if d.config.ErrorUnused && len(dataValKeysUnused) > 0 {
 		keys := make([]string, 0, len(dataValKeysUnused))
 		for rawKey, _ := range dataValKeysUnused {
-			keys = append(keys, rawKey.(string))
+			keys = append(keys, fmt.Sprint(rawKey))
 		}
 		sort.Strings(keys)
 
@@ -738,7 +2005,7 @@ func (d *Decoder) decodeStruct(name string, data interface{}, val reflect.Value)
 	// Add the unused keys to the list of unused keys if we're tracking metadata
 	if d.config.Metadata != nil {
 		for rawKey, _ := range dataValKeysUnused {
-			key := rawKey.(string)
+			key := fmt.Sprint(rawKey)
 			if name != "" {
 				key = fmt.Sprintf("%s.%s", name, key)
 			}
@@ -750,6 +2017,409 @@ func (d *Decoder) decodeStruct(name string, data interface{}, val reflect.Value)
 	return nil
 }
 
+// nilType is the type given to decode hooks for nil data.
+var nilType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// errorType is the type of the error that a setter method may return.
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// typeOf returns the type of data, or nilType if data is nil.
+func typeOf(data interface{}) reflect.Type {
+	if data == nil {
+		return nilType
+	}
+
+	return reflect.TypeOf(data)
+}
+
+// squashedStruct is a struct whose fields are decoded as if they were
+// fields of the struct being decoded. The index is the path of fields
+// to it from the struct being decoded, as for reflect.Value.FieldByIndex,
+// and may go through pointers. The depth is how many squashed structs
+// deep it is, and the prefix is put in front of the keys of its fields.
+type squashedStruct struct {
+	typ    reflect.Type
+	index  []int
+	depth  int
+	prefix string
+}
+
+// structField is a field that decodeStruct decodes, along with the key
+// it is decoded from.
+type structField struct {
+	name    string
+	aliases []string
+	tag     fieldTag
+	index   []int
+	depth   int
+}
+
+// findMapKey returns the key of the map dataVal that matches name, and its
+// value. It looks for an exact match first and then does a slower search
+// over keys, the set of dataVal's keys, without regard to case. Both
+// returned Values are the zero Value if there is no match.
+func findMapKey(dataVal reflect.Value, keys map[reflect.Value]struct{}, name string) (reflect.Value, reflect.Value) {
+	// The map's keys may be of a named string type
+	rawMapKey := reflect.ValueOf(name)
+	if dataVal.Type().Key().Kind() == reflect.String {
+		rawMapKey = rawMapKey.Convert(dataVal.Type().Key())
+	}
+
+	rawMapVal := dataVal.MapIndex(rawMapKey)
+	if rawMapVal.IsValid() {
+		return rawMapKey, rawMapVal
+	}
+
+	for dataValKey := range keys {
+		mK := dataValKey
+		if mK.Kind() == reflect.Interface {
+			mK = mK.Elem()
+		}
+		if mK.Kind() != reflect.String {
+			// Not a string key
+			continue
+		}
+
+		if strings.EqualFold(mK.String(), name) {
+			return dataValKey, dataVal.MapIndex(dataValKey)
+		}
+	}
+
+	return reflect.Value{}, reflect.Value{}
+}
+
+// joinName returns the name of the field of the value with the given
+// name. If the name is empty string, then we're at the root, and we
+// don't dot-join the fields.
+func joinName(name string, field string) string {
+	if name == "" {
+		return field
+	}
+
+	return fmt.Sprintf("%s.%s", name, field)
+}
+
+// squashCycle reports whether the squashed struct at the given index path
+// from a struct of type root has the same type as a struct it is in.
+func squashCycle(root reflect.Type, index []int) bool {
+	parents := make([]reflect.Type, 0, len(index))
+	t := root
+	for _, i := range index {
+		parents = append(parents, t)
+		t = t.Field(i).Type
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+	}
+
+	for _, parent := range parents {
+		if parent == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dominantFields drops the fields that are shadowed by other fields with
+// the same name, following Go's rules for promoted fields: the shallowest
+// field wins and, if there are several at that depth, the one with a name
+// in its tag wins. If that doesn't settle it, none of them are decoded.
+// Names are compared without regard to case, as keys are matched that way.
+func dominantFields(fields []structField) []structField {
+	byName := make(map[string][]int)
+	for i, f := range fields {
+		name := strings.ToLower(f.name)
+		byName[name] = append(byName[name], i)
+	}
+
+	result := make([]structField, 0, len(fields))
+	for i, f := range fields {
+		same := byName[strings.ToLower(f.name)]
+		if len(same) == 1 {
+			result = append(result, f)
+			continue
+		}
+
+		// Only consider the group once, at its first field
+		if same[0] != i {
+			continue
+		}
+
+		var shallowest []int
+		for _, j := range same {
+			switch {
+			case len(shallowest) == 0 || fields[j].depth < fields[shallowest[0]].depth:
+				shallowest = []int{j}
+			case fields[j].depth == fields[shallowest[0]].depth:
+				shallowest = append(shallowest, j)
+			}
+		}
+
+		if len(shallowest) == 1 {
+			result = append(result, fields[shallowest[0]])
+			continue
+		}
+
+		var tagged []int
+		for _, j := range shallowest {
+			if fields[j].tag.Name != "" {
+				tagged = append(tagged, j)
+			}
+		}
+
+		if len(tagged) == 1 {
+			result = append(result, fields[tagged[0]])
+		}
+	}
+
+	return result
+}
+
+// appendIndex returns the index path of the i-th field of the struct
+// with the given index path. The result doesn't share memory with index,
+// since paths that branch from it are built from the same slice.
+func appendIndex(index []int, i int) []int {
+	result := make([]int, len(index)+1)
+	copy(result, index)
+	result[len(index)] = i
+	return result
+}
+
+// fieldByIndex returns the field of the struct v with the given index
+// path, like reflect.Value.FieldByIndex. Nil pointers to squashed
+// structs along the path are allocated, but only if the field can be
+// set, so that they stay nil if none of their fields are decoded. The
+// zero Value is returned if the field can't be set because it is
+// unexported, and an error if it is behind a nil pointer that can't be
+// set, such as an embedded pointer to an unexported struct.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	var ptrs, elems []reflect.Value
+	var blocked reflect.Type
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() && blocked == nil {
+					blocked = v.Type().Elem()
+				}
+
+				ptrs = append(ptrs, v)
+				elems = append(elems, reflect.New(v.Type().Elem()))
+				v = elems[len(elems)-1]
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+
+	if !v.CanSet() {
+		return reflect.Value{}, nil
+	}
+	if blocked != nil {
+		return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct %s", blocked)
+	}
+
+	for i := range ptrs {
+		ptrs[i].Set(elems[i])
+	}
+
+	return v, nil
+}
+
+// setter returns the setter method of the struct that has the field f,
+// where val is the struct being decoded. This is the method named by the
+// "setter" tag option or, for an unexported field, Set followed by the
+// name of the field, such as SetMaxConns for maxConns. The zero Value is
+// returned if the field has no setter.
+func setter(val reflect.Value, f structField) (reflect.Value, error) {
+	fieldType := val.Type().FieldByIndex(f.index)
+	methodName, ok := f.tag.option("setter")
+	if !ok {
+		if fieldType.IsExported() {
+			return reflect.Value{}, nil
+		}
+
+		r, size := utf8.DecodeRuneInString(fieldType.Name)
+		methodName = "Set" + string(unicode.ToUpper(r)) + fieldType.Name[size:]
+	}
+
+	// The method is looked up on the type first, so that a nil pointer
+	// to a squashed struct is only allocated if it has the method.
+	parentIndex := f.index[:len(f.index)-1]
+	parentType := val.Type()
+	if len(parentIndex) > 0 {
+		parentType = val.Type().FieldByIndex(parentIndex).Type
+		if parentType.Kind() == reflect.Ptr {
+			parentType = parentType.Elem()
+		}
+	}
+
+	method, found := reflect.PtrTo(parentType).MethodByName(methodName)
+	if !found {
+		if ok {
+			return reflect.Value{}, fmt.Errorf("has no setter method %s", methodName)
+		}
+		return reflect.Value{}, nil
+	}
+
+	methodType := method.Type
+	if methodType.NumIn() != 2 || methodType.NumOut() > 1 ||
+		methodType.NumOut() == 1 && methodType.Out(0) != errorType {
+		return reflect.Value{}, fmt.Errorf(
+			"setter %s must take one argument and return nothing or an error", methodName)
+	}
+
+	parent := val
+	if len(parentIndex) > 0 {
+		var err error
+		parent, err = fieldByIndex(val, parentIndex)
+		if err != nil || !parent.IsValid() {
+			return reflect.Value{}, err
+		}
+
+		if parent.Kind() == reflect.Ptr {
+			if parent.IsNil() {
+				parent.Set(reflect.New(parentType))
+			}
+			parent = parent.Elem()
+		}
+	}
+
+	return parent.Addr().MethodByName(methodName), nil
+}
+
+// callSetter decodes data into a new value of the type of the argument
+// of a setter method, and calls the method with it.
+func (d *Decoder) callSetter(name string, data interface{}, method reflect.Value) error {
+	arg := reflect.New(method.Type().In(0)).Elem()
+	if err := d.decode(name, data, arg); err != nil {
+		return err
+	}
+
+	out := method.Call([]reflect.Value{arg})
+	if len(out) == 1 && !out[0].IsNil() {
+		return fmt.Errorf("'%s' %s", name, out[0].Interface())
+	}
+
+	return nil
+}
+
+// primaryField returns the index of the field of a struct that has the
+// "primary" tag option, if there is one.
+func (d *Decoder) primaryField(structType reflect.Type) (int, bool) {
+	for i := 0; i < structType.NumField(); i++ {
+		if d.structTag(structType.Field(i)).has("primary") {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// decodePrimary decodes data that isn't a map into the i-th field of a
+// struct, which is its primary field. The other fields are left alone.
+func (d *Decoder) decodePrimary(name string, data interface{}, val reflect.Value, i int) error {
+	fieldType := val.Type().Field(i)
+	tag := d.structTag(fieldType)
+
+	fieldName := fieldType.Name
+	if tag.Name != "" {
+		fieldName = tag.Name
+	}
+	if name != "" {
+		fieldName = fmt.Sprintf("%s.%s", name, fieldName)
+	}
+
+	field := val.Field(i)
+	if !field.CanSet() {
+		return fmt.Errorf("'%s' primary field %s is not exported", name, fieldType.Name)
+	}
+
+	return d.field(tag).decode(fieldName, data, field)
+}
+
+// isTuple reports whether a struct of the given type is decoded from a
+// list, which is the case if the field being decoded has the "tuple" tag
+// option or the struct has a blank field with that option.
+func (d *Decoder) isTuple(structType reflect.Type) bool {
+	if d.tag.has("tuple") {
+		return true
+	}
+
+	for i := 0; i < structType.NumField(); i++ {
+		fieldType := structType.Field(i)
+		if fieldType.Name == "_" && d.structTag(fieldType).has("tuple") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decodeTuple decodes a list into the exported fields of a struct by
+// position. Fields take the position after the previous field, starting
+// at 0, unless they have the "index" tag option. The list must have an
+// element for every position.
+func (d *Decoder) decodeTuple(name string, dataVal reflect.Value, val reflect.Value) error {
+	structType := val.Type()
+
+	positions := make(map[int]int)
+	length := 0
+	next := 0
+	for i := 0; i < structType.NumField(); i++ {
+		fieldType := structType.Field(i)
+		tag := d.structTag(fieldType)
+		if !fieldType.IsExported() || tag.Skip {
+			continue
+		}
+
+		pos := next
+		if opt, ok := tag.option("index"); ok {
+			var err error
+			pos, err = strconv.Atoi(opt)
+			if err != nil || pos < 0 {
+				return fmt.Errorf("'%s' field %s has invalid index '%s'", name, fieldType.Name, opt)
+			}
+		}
+
+		if other, ok := positions[pos]; ok {
+			return fmt.Errorf("'%s' fields %s and %s have the same index %d",
+				name, structType.Field(other).Name, fieldType.Name, pos)
+		}
+
+		positions[pos] = i
+		next = pos + 1
+		if next > length {
+			length = next
+		}
+	}
+
+	if dataVal.Len() != length {
+		return fmt.Errorf("'%s' expected %d elements, got %d", name, length, dataVal.Len())
+	}
+
+	errors := make([]string, 0)
+
+	for pos := 0; pos < length; pos++ {
+		i, ok := positions[pos]
+		if !ok {
+			continue
+		}
+
+		fieldName := fmt.Sprintf("%s[%d]", name, pos)
+		tag := d.structTag(structType.Field(i))
+		if err := d.field(tag).decode(fieldName, dataVal.Index(pos).Interface(), val.Field(i)); err != nil {
+			errors = appendErrors(errors, err)
+		}
+	}
+
+	if len(errors) > 0 {
+		return &Error{errors}
+	}
+
+	return nil
+}
+
 func getKind(val reflect.Value) reflect.Kind {
 	kind := val.Kind()
 
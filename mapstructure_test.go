@@ -1,9 +1,12 @@
 package mapstructure
 
 import (
+	"errors"
 	"fmt"
+	"net/netip"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -33,11 +36,45 @@ type EmbeddedPointer struct {
 	Vunique string
 }
 
+type EmbeddedPointerSquash struct {
+	*Basic  `mapstructure:",squash"`
+	Vunique string
+}
+
 type EmbeddedSquash struct {
 	Basic   `mapstructure:",squash"`
 	Vunique string
 }
 
+type SetterPool struct {
+	maxConns int
+	name     string
+	Port     int `mapstructure:"port,setter=SetPort"`
+}
+
+func (p *SetterPool) SetMaxConns(n int) error {
+	if n <= 0 {
+		return errors.New("must be positive")
+	}
+	p.maxConns = n
+	return nil
+}
+
+func (p *SetterPool) SetName(name string) {
+	p.name = strings.ToLower(name)
+}
+
+func (p *SetterPool) SetPort(port string) error {
+	n, err := strconv.Atoi(strings.TrimPrefix(port, ":"))
+	p.Port = n
+	return err
+}
+
+type SetterServer struct {
+	Pool        SetterPool `mapstructure:"pool"`
+	*SetterPool `mapstructure:",squash"`
+}
+
 type SquashOnNonStructType struct {
 	InvalidSquashType int `mapstructure:",squash"`
 }
@@ -75,6 +112,43 @@ type Tagged struct {
 	Value string `mapstructure:"foo"`
 }
 
+type Shape interface {
+	Area() float64
+}
+
+type Circle struct {
+	Radius float64
+}
+
+func (c Circle) Area() float64 { return 3 * c.Radius * c.Radius }
+
+type Square struct {
+	Side float64
+}
+
+func (s *Square) Area() float64 { return s.Side * s.Side }
+
+type Shapes struct {
+	Main  Shape
+	List  []Shape
+	Named map[string]Shape
+}
+
+type Level int
+
+func (l *Level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "low":
+		*l = 1
+	case "high":
+		*l = 2
+	default:
+		return fmt.Errorf("unknown level %q", text)
+	}
+
+	return nil
+}
+
 type TypeConversionResult struct {
 	IntToFloat         float32
 	IntToUint          uint
@@ -191,6 +265,75 @@ func TestBasic_Merge(t *testing.T) {
 	}
 }
 
+func TestBasic_MergePointer(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vbar": map[string]interface{}{
+			"vint": 42,
+		},
+	}
+
+	vbar := &Basic{Vuint: 100}
+	result := NestedPointer{Vbar: vbar}
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	if result.Vbar != vbar {
+		t.Fatalf("pointer should be kept: %#v", result.Vbar)
+	}
+
+	expected := Basic{
+		Vint:  42,
+		Vuint: 100,
+	}
+	if !reflect.DeepEqual(*result.Vbar, expected) {
+		t.Fatalf("bad: %#v", *result.Vbar)
+	}
+}
+
+func TestBasic_MergePointerZeroFields(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vbar": map[string]interface{}{
+			"vint": 42,
+		},
+	}
+
+	vbar := &Basic{Vuint: 100}
+	result := NestedPointer{Vbar: vbar}
+	config := &DecoderConfig{
+		ZeroFields: true,
+		Result:     &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	if result.Vbar == vbar {
+		t.Fatal("pointer should be replaced")
+	}
+	if vbar.Vint != 0 {
+		t.Fatalf("old value should be untouched: %#v", vbar)
+	}
+
+	expected := Basic{
+		Vint: 42,
+	}
+	if !reflect.DeepEqual(*result.Vbar, expected) {
+		t.Fatalf("bad: %#v", *result.Vbar)
+	}
+}
+
 func TestDecode_BasicSquash(t *testing.T) {
 	t.Parallel()
 
@@ -248,12 +391,20 @@ func TestDecode_EmbeddedPointer(t *testing.T) {
 
 	var result EmbeddedPointer
 	err := Decode(input, &result)
-	if err == nil {
-		t.Fatal("should get error")
+	if err != nil {
+		t.Fatalf("got an err: %s", err.Error())
+	}
+
+	if result.Basic == nil || result.Vstring != "innerfoo" {
+		t.Errorf("vstring value should be 'innerfoo': %#v", result.Basic)
+	}
+
+	if result.Vunique != "bar" {
+		t.Errorf("vunique value should be 'bar': %#v", result.Vunique)
 	}
 }
 
-func TestDecode_EmbeddedSquash(t *testing.T) {
+func TestDecode_EmbeddedPointerSquash(t *testing.T) {
 	t.Parallel()
 
 	input := map[string]interface{}{
@@ -261,56 +412,154 @@ func TestDecode_EmbeddedSquash(t *testing.T) {
 		"vunique": "bar",
 	}
 
-	var result EmbeddedSquash
+	var result EmbeddedPointerSquash
 	err := Decode(input, &result)
 	if err != nil {
 		t.Fatalf("got an err: %s", err.Error())
 	}
 
-	if result.Vstring != "foo" {
-		t.Errorf("vstring value should be 'foo': %#v", result.Vstring)
+	if result.Basic == nil || result.Vstring != "foo" {
+		t.Errorf("vstring value should be 'foo': %#v", result.Basic)
 	}
 
 	if result.Vunique != "bar" {
 		t.Errorf("vunique value should be 'bar': %#v", result.Vunique)
 	}
+
+	// The pointer stays nil if none of its keys are present
+	result = EmbeddedPointerSquash{}
+	err = Decode(map[string]interface{}{"vunique": "bar"}, &result)
+	if err != nil {
+		t.Fatalf("got an err: %s", err.Error())
+	}
+
+	if result.Basic != nil {
+		t.Errorf("basic should be nil: %#v", result.Basic)
+	}
 }
 
-func TestDecode_SquashOnNonStructType(t *testing.T) {
+func TestDecode_EmbeddedPointerUnexported(t *testing.T) {
+	t.Parallel()
+
+	type inner struct {
+		Value string
+	}
+
+	type Outer struct {
+		*inner `mapstructure:",squash"`
+		Name   string
+	}
+
+	// A nil pointer to an unexported struct can't be allocated
+	var result Outer
+	err := Decode(map[string]interface{}{"name": "foo", "value": "bar"}, &result)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "'Value' cannot set embedded pointer to unexported struct") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+	if result.Name != "foo" || result.inner != nil {
+		t.Errorf("bad: %#v", result)
+	}
+
+	// An existing one is decoded into
+	result = Outer{inner: &inner{}}
+	err = Decode(map[string]interface{}{"value": "bar"}, &result)
+	if err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if result.Value != "bar" {
+		t.Errorf("bad: %#v", result.inner)
+	}
+}
+func TestDecode_EmbeddedNonStruct(t *testing.T) {
 	t.Parallel()
 
+	type Name string
+
+	type Embedded struct {
+		Name
+		Shape
+		Vunique string
+	}
+
 	input := map[string]interface{}{
-		"InvalidSquashType": 42,
+		"name":    "foo",
+		"shape":   Circle{Radius: 1},
+		"vunique": "bar",
 	}
 
-	var result SquashOnNonStructType
+	var result Embedded
 	err := Decode(input, &result)
-	if err == nil {
-		t.Fatal("unexpected success decoding invalid squash field type")
-	} else if !strings.Contains(err.Error(), "unsupported type for squash") {
-		t.Fatalf("unexpected error message for invalid squash field type: %s", err)
+	if err != nil {
+		t.Fatalf("got an err: %s", err.Error())
+	}
+
+	expected := Embedded{
+		Name:    "foo",
+		Shape:   Circle{Radius: 1},
+		Vunique: "bar",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
 	}
 }
 
-func TestDecode_DecodeHook(t *testing.T) {
+func TestDecode_EmbeddedSquash(t *testing.T) {
 	t.Parallel()
 
 	input := map[string]interface{}{
-		"vint": "WHAT",
+		"vstring": "foo",
+		"vunique": "bar",
 	}
 
-	decodeHook := func(from reflect.Kind, to reflect.Kind, v interface{}) (interface{}, error) {
-		if from == reflect.String && to != reflect.String {
-			return 5, nil
-		}
+	var result EmbeddedSquash
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got an err: %s", err.Error())
+	}
 
-		return v, nil
+	if result.Vstring != "foo" {
+		t.Errorf("vstring value should be 'foo': %#v", result.Vstring)
 	}
 
-	var result Basic
+	if result.Vunique != "bar" {
+		t.Errorf("vunique value should be 'bar': %#v", result.Vunique)
+	}
+}
+
+func TestDecode_SquashConfig(t *testing.T) {
+	t.Parallel()
+
+	type Name struct {
+		Vstring string
+	}
+
+	type Other struct {
+		Vint int
+	}
+
+	type Promoted struct {
+		Basic
+		*Name
+		Other   `mapstructure:"other"`
+		Vstring string
+	}
+
+	input := map[string]interface{}{
+		"vstring": "outer",
+		"vint":    42,
+		"vbool":   true,
+		"other": map[string]interface{}{
+			"vint": 7,
+		},
+	}
+
+	var result Promoted
 	config := &DecoderConfig{
-		DecodeHook: decodeHook,
-		Result:     &result,
+		Squash: true,
+		Result: &result,
 	}
 
 	decoder, err := NewDecoder(config)
@@ -318,36 +567,49 @@ func TestDecode_DecodeHook(t *testing.T) {
 		t.Fatalf("err: %s", err)
 	}
 
-	err = decoder.Decode(input)
-	if err != nil {
+	if err := decoder.Decode(input); err != nil {
 		t.Fatalf("got an err: %s", err)
 	}
 
-	if result.Vint != 5 {
-		t.Errorf("vint should be 5: %#v", result.Vint)
+	expected := Promoted{
+		Basic:   Basic{Vint: 42, Vbool: true},
+		Other:   Other{Vint: 7},
+		Vstring: "outer",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
 	}
 }
 
-func TestDecode_DecodeHookType(t *testing.T) {
+func TestDecode_SquashConflict(t *testing.T) {
 	t.Parallel()
 
-	input := map[string]interface{}{
-		"vint": "WHAT",
+	type A struct {
+		Vstring string
+		Vint    int
 	}
 
-	decodeHook := func(from reflect.Type, to reflect.Type, v interface{}) (interface{}, error) {
-		if from.Kind() == reflect.String &&
-			to.Kind() != reflect.String {
-			return 5, nil
-		}
+	type B struct {
+		Vstring string
+		Vint    int `mapstructure:"vint"`
+	}
 
-		return v, nil
+	type Conflict struct {
+		A `mapstructure:",squash"`
+		B `mapstructure:",squash"`
 	}
 
-	var result Basic
+	input := map[string]interface{}{
+		"vstring": "foo",
+		"vint":    42,
+	}
+
+	var md Metadata
+	var result Conflict
 	config := &DecoderConfig{
-		DecodeHook: decodeHook,
-		Result:     &result,
+		Squash:   true,
+		Metadata: &md,
+		Result:   &result,
 	}
 
 	decoder, err := NewDecoder(config)
@@ -355,80 +617,745 @@ func TestDecode_DecodeHookType(t *testing.T) {
 		t.Fatalf("err: %s", err)
 	}
 
-	err = decoder.Decode(input)
-	if err != nil {
+	if err := decoder.Decode(input); err != nil {
 		t.Fatalf("got an err: %s", err)
 	}
 
-	if result.Vint != 5 {
-		t.Errorf("vint should be 5: %#v", result.Vint)
+	// Vstring is ambiguous, B's Vint has a name in its tag
+	expected := Conflict{B: B{Vint: 42}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	if !reflect.DeepEqual(md.Unused, []string{"vstring"}) {
+		t.Fatalf("bad unused: %#v", md.Unused)
 	}
 }
 
-func TestDecode_Nil(t *testing.T) {
+func TestDecode_SquashSameName(t *testing.T) {
 	t.Parallel()
 
-	var input interface{} = nil
-	result := Basic{
-		Vstring: "foo",
+	type A struct {
+		Name string
 	}
 
-	err := Decode(input, &result)
-	if err != nil {
-		t.Fatalf("err: %s", err)
+	type B struct {
+		Name string
 	}
 
-	if result.Vstring != "foo" {
-		t.Fatalf("bad: %#v", result.Vstring)
+	type Both struct {
+		A `mapstructure:",squash"`
+		B `mapstructure:",squash"`
 	}
-}
 
-func TestDecode_NonStruct(t *testing.T) {
-	t.Parallel()
+	type Outer struct {
+		A    `mapstructure:",squash"`
+		Name string
+	}
 
 	input := map[string]interface{}{
-		"foo": "bar",
-		"bar": "baz",
+		"name": "x",
 	}
 
-	var result map[string]string
-	err := Decode(input, &result)
-	if err != nil {
-		t.Fatalf("err: %s", err)
+	// Without the Squash option, every field with the name is decoded
+	var both Both
+	if err := Decode(input, &both); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if both.A.Name != "x" || both.B.Name != "x" {
+		t.Fatalf("bad: %#v", both)
 	}
 
-	if result["foo"] != "bar" {
-		t.Fatal("foo is not bar")
+	var outer Outer
+	if err := Decode(input, &outer); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if outer.A.Name != "x" || outer.Name != "x" {
+		t.Fatalf("bad: %#v", outer)
 	}
 }
 
-func TestDecode_StructMatch(t *testing.T) {
+func TestDecode_SquashPrefix(t *testing.T) {
 	t.Parallel()
 
-	input := map[string]interface{}{
-		"vbar": Basic{
-			Vstring: "foo",
-		},
+	type PoolConfig struct {
+		Max  int
+		Idle int
 	}
 
-	var result Nested
-	err := Decode(input, &result)
-	if err != nil {
-		t.Fatalf("got an err: %s", err.Error())
+	type Pools struct {
+		DB    PoolConfig  `mapstructure:",squash,prefix=db_"`
+		Cache *PoolConfig `mapstructure:",squash,prefix=cache_"`
 	}
 
-	if result.Vbar.Vstring != "foo" {
-		t.Errorf("bad: %#v", result)
+	input := map[string]interface{}{
+		"db_max":     10,
+		"db_idle":    2,
+		"cache_max":  "bad",
+		"cache_idle": 1,
+		"max":        5,
 	}
-}
 
-func TestDecode_TypeConversion(t *testing.T) {
-	input := map[string]interface{}{
-		"IntToFloat":         42,
-		"IntToUint":          42,
-		"IntToBool":          1,
-		"IntToString":        42,
-		"UintToInt":          42,
+	var md Metadata
+	var result Pools
+	config := &DecoderConfig{
+		ErrorUnused: true,
+		Metadata:    &md,
+		Result:      &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(input)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "'cache_Max' expected type 'int'") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+	if !strings.Contains(err.Error(), "'' has invalid keys: max") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	if result.DB != (PoolConfig{Max: 10, Idle: 2}) {
+		t.Errorf("bad: %#v", result.DB)
+	}
+	if result.Cache == nil || result.Cache.Idle != 1 {
+		t.Errorf("bad: %#v", result.Cache)
+	}
+
+	sort.Strings(md.Keys)
+	expectedKeys := []string{"cache_Idle", "cache_Max", "db_Idle", "db_Max"}
+	if !reflect.DeepEqual(md.Keys, expectedKeys) {
+		t.Fatalf("bad keys: %#v", md.Keys)
+	}
+}
+
+func TestDecode_Alias(t *testing.T) {
+	t.Parallel()
+
+	type Config struct {
+		MaxConns int    `mapstructure:"max_conns,alias=maxConnections|maxconn,deprecated"`
+		Host     string `mapstructure:"host,alias=hostname"`
+		Port     int    `mapstructure:"port,deprecated"`
+	}
+
+	var md Metadata
+	var result Config
+	config := &DecoderConfig{
+		ErrorUnused: true,
+		Metadata:    &md,
+		Result:      &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input := map[string]interface{}{
+		"maxConnections": 10,
+		"hostname":       "db",
+		"port":           5432,
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Config{MaxConns: 10, Host: "db", Port: 5432}
+	if result != expected {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	sort.Strings(md.Warnings)
+	expectedWarnings := []string{
+		"'maxConnections' is deprecated, use 'max_conns' instead",
+		"'port' is deprecated",
+	}
+	if !reflect.DeepEqual(md.Warnings, expectedWarnings) {
+		t.Fatalf("bad warnings: %#v", md.Warnings)
+	}
+
+	// The new and old key may not both be set.
+	result = Config{}
+	input = map[string]interface{}{
+		"max_conns": 10,
+		"maxconn":   20,
+	}
+	err = Decode(input, &result)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "'max_conns' and 'maxconn' are both set") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+	if result.MaxConns != 0 {
+		t.Errorf("bad: %#v", result)
+	}
+
+	// An alias that differs only by case isn't a second key
+	var cased struct {
+		MaxConns int `mapstructure:"maxconns,alias=MaxConns"`
+	}
+	if err := Decode(map[string]interface{}{"maxconns": 5}, &cased); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if cased.MaxConns != 5 {
+		t.Fatalf("bad: %#v", cased)
+	}
+}
+
+func TestDecode_TagNames(t *testing.T) {
+	t.Parallel()
+
+	type Address struct {
+		City string `json:"city"`
+	}
+
+	type Person struct {
+		Name     string `json:"name,omitempty"`
+		Age      int    `mapstructure:"years" json:"age"`
+		Email    string `yaml:"mail" json:"-"`
+		Password string `json:"-"`
+		Address  `yaml:",inline"`
+	}
+
+	var result Person
+	config := &DecoderConfig{
+		ErrorUnused: true,
+		TagNames:    []string{"mapstructure", "json", "yaml"},
+		Result:      &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input := map[string]interface{}{
+		"name":  "Ada",
+		"years": 36,
+		"city":  "London",
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Person{Name: "Ada", Age: 36, Address: Address{City: "London"}}
+	if result != expected {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// Fields tagged "-" are not decoded, even if their name is in the data.
+	for _, key := range []string{"email", "mail", "password", "-"} {
+		result = Person{}
+		err := decoder.Decode(map[string]interface{}{key: "x"})
+		if err == nil {
+			t.Fatalf("%s: expected error", key)
+		}
+		if !strings.Contains(err.Error(), "has invalid keys: "+key) {
+			t.Errorf("%s: got unexpected error: %s", key, err)
+		}
+	}
+}
+
+func TestDecode_StrictTags(t *testing.T) {
+	t.Parallel()
+
+	type Inner struct {
+		Vals []int `mapstructure:"vals,merge=apend"`
+	}
+
+	type Bad struct {
+		Basic  `mapstructure:"basic,sqaush"`
+		Inner  `mapstructure:"inner,squash"`
+		Port   int    `mapstructure:"port,prefix=p_"`
+		Data   []byte `mapstructure:"data,encoding=base32"`
+		Nested map[string]*Inner
+		Secret string `mapstructure:"-"`
+	}
+
+	var result Bad
+	_, err := NewDecoder(&DecoderConfig{
+		StrictTags: true,
+		Result:     &result,
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	for _, msg := range []string{
+		"mapstructure.Bad field Basic: unknown tag option 'sqaush'",
+		"mapstructure.Bad field Inner: squash conflicts with the name 'inner'",
+		"mapstructure.Bad field Port: prefix requires squash",
+		"mapstructure.Bad field Data: invalid value 'base32' for encoding",
+		"mapstructure.Inner field Vals: invalid value 'apend' for merge",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("missing %q in error: %s", msg, err)
+		}
+	}
+	if len(err.(*Error).Errors) != 5 {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	// Valid tags, including those of other encodings, are accepted.
+	type Good struct {
+		Basic `mapstructure:",squash"`
+		Name  string `json:"name,omitempty"`
+		Port  int    `mapstructure:"port,alias=p,deprecated"`
+		Skip  string `mapstructure:"-"`
+		Count int    `json:"count,string,omitzero"`
+		Items []int  `yaml:"items,flow"`
+	}
+
+	var good Good
+	_, err = NewDecoder(&DecoderConfig{
+		StrictTags: true,
+		TagNames:   []string{"mapstructure", "json", "yaml"},
+		Result:     &good,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestDecode_SquashThreeDeep(t *testing.T) {
+	t.Parallel()
+
+	type Level3 struct {
+		C string
+	}
+
+	type Level2 struct {
+		B      string
+		Level3 `mapstructure:",squash"`
+	}
+
+	type Level1 struct {
+		Level2 `mapstructure:",squash"`
+		A      string
+	}
+
+	type Root struct {
+		Z       int
+		*Level1 `mapstructure:",squash"`
+	}
+
+	input := map[string]interface{}{
+		"z": 1,
+		"a": "a",
+		"b": "b",
+		"c": "c",
+	}
+
+	var result Root
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Z != 1 || result.Level1 == nil {
+		t.Fatalf("bad: %#v", result)
+	}
+	if result.A != "a" || result.B != "b" || result.C != "c" {
+		t.Fatalf("bad: %#v", result.Level1)
+	}
+}
+
+func TestDecode_SquashThreeDeepPointers(t *testing.T) {
+	t.Parallel()
+
+	type Level3 struct {
+		C string
+	}
+
+	type Level2 struct {
+		B       string
+		*Level3 `mapstructure:",squash"`
+	}
+
+	type Level1 struct {
+		*Level2 `mapstructure:",squash"`
+		A       string
+	}
+
+	type Root struct {
+		Level1 `mapstructure:",squash"`
+	}
+
+	var result Root
+	if err := Decode(map[string]interface{}{"c": "c"}, &result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Level2 == nil || result.Level3 == nil || result.C != "c" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// Pointers to structs without any decoded fields stay nil
+	result = Root{}
+	if err := Decode(map[string]interface{}{"a": "a"}, &result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.A != "a" || result.Level2 != nil {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	result = Root{}
+	if err := Decode(map[string]interface{}{"b": "b"}, &result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Level2 == nil || result.B != "b" || result.Level3 != nil {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// Existing pointers are decoded into
+	level3 := &Level3{C: "old"}
+	result = Root{Level1{Level2: &Level2{B: "keep", Level3: level3}}}
+	if err := Decode(map[string]interface{}{"c": "new"}, &result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Level3 != level3 || level3.C != "new" || result.B != "keep" {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestDecode_SquashRecursive(t *testing.T) {
+	t.Parallel()
+
+	type Node struct {
+		Name  string
+		*Node `mapstructure:",squash"`
+	}
+
+	var result Node
+	if err := Decode(map[string]interface{}{"name": "root"}, &result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Name != "root" || result.Node != nil {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestDecode_Setters(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"pool": map[string]interface{}{
+			"maxConns": 10,
+			"name":     "Primary",
+			"port":     ":5432",
+		},
+		"maxconns": "20",
+	}
+
+	var result SetterServer
+	config := &DecoderConfig{
+		Setters:          true,
+		WeaklyTypedInput: true,
+		Result:           &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := SetterPool{maxConns: 10, name: "primary", Port: 5432}
+	if result.Pool != expected {
+		t.Fatalf("bad: %#v", result.Pool)
+	}
+	if result.SetterPool == nil || *result.SetterPool != (SetterPool{maxConns: 20}) {
+		t.Fatalf("bad: %#v", result.SetterPool)
+	}
+
+	// Setter errors are reported with the path of the field
+	result = SetterServer{}
+	input = map[string]interface{}{
+		"pool": map[string]interface{}{
+			"maxConns": -1,
+			"port":     "http",
+		},
+	}
+	err = decoder.Decode(input)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "'pool.maxConns' must be positive") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+	if !strings.Contains(err.Error(), `'pool.port' strconv.Atoi: parsing "http": invalid syntax`) {
+		t.Errorf("got unexpected error: %s", err)
+	}
+	if result.SetterPool != nil {
+		t.Errorf("bad: %#v", result.SetterPool)
+	}
+
+	// Without Setters, unexported fields are left alone
+	result = SetterServer{}
+	input = map[string]interface{}{
+		"pool": map[string]interface{}{
+			"maxConns": 10,
+			"port":     5432,
+		},
+	}
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Pool != (SetterPool{Port: 5432}) {
+		t.Fatalf("bad: %#v", result.Pool)
+	}
+}
+
+func TestDecode_SquashOnNonStructType(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"InvalidSquashType": 42,
+	}
+
+	var result SquashOnNonStructType
+	err := Decode(input, &result)
+	if err == nil {
+		t.Fatal("unexpected success decoding invalid squash field type")
+	} else if !strings.Contains(err.Error(), "unsupported type for squash") {
+		t.Fatalf("unexpected error message for invalid squash field type: %s", err)
+	}
+}
+
+func TestDecode_DecodeHook(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vint": "WHAT",
+	}
+
+	decodeHook := func(from reflect.Kind, to reflect.Kind, v interface{}) (interface{}, error) {
+		if from == reflect.String && to != reflect.String {
+			return 5, nil
+		}
+
+		return v, nil
+	}
+
+	var result Basic
+	config := &DecoderConfig{
+		DecodeHook: decodeHook,
+		Result:     &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(input)
+	if err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	if result.Vint != 5 {
+		t.Errorf("vint should be 5: %#v", result.Vint)
+	}
+}
+
+func TestDecode_DecodeHookType(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vint": "WHAT",
+	}
+
+	decodeHook := func(from reflect.Type, to reflect.Type, v interface{}) (interface{}, error) {
+		if from.Kind() == reflect.String &&
+			to.Kind() != reflect.String {
+			return 5, nil
+		}
+
+		return v, nil
+	}
+
+	var result Basic
+	config := &DecoderConfig{
+		DecodeHook: decodeHook,
+		Result:     &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(input)
+	if err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	if result.Vint != 5 {
+		t.Errorf("vint should be 5: %#v", result.Vint)
+	}
+}
+
+func TestDecode_Nil(t *testing.T) {
+	t.Parallel()
+
+	var input interface{} = nil
+	result := Basic{
+		Vstring: "foo",
+	}
+
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Vstring != "foo" {
+		t.Fatalf("bad: %#v", result.Vstring)
+	}
+}
+
+func TestDecode_NilBehavior(t *testing.T) {
+	t.Parallel()
+
+	type Settings struct {
+		Name    string
+		Timeout *int
+		Tags    map[string]string `mapstructure:"tags,nil=ignore"`
+		Port    int               `mapstructure:"port,nil=error"`
+	}
+
+	timeout := 5
+	input := map[string]interface{}{
+		"name":    nil,
+		"timeout": nil,
+		"tags":    nil,
+	}
+
+	result := Settings{
+		Name:    "foo",
+		Timeout: &timeout,
+		Tags:    map[string]string{"a": "b"},
+	}
+	config := &DecoderConfig{
+		NilBehavior: NilZero,
+		Result:      &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Settings{Tags: map[string]string{"a": "b"}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	err = decoder.Decode(map[string]interface{}{"port": nil})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "'port' must not be null") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+}
+
+func TestDecode_DecodeHookNil(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vstring": nil,
+	}
+
+	decodeHook := func(from reflect.Type, to reflect.Type, v interface{}) (interface{}, error) {
+		if v == nil && to.Kind() == reflect.String {
+			return "default", nil
+		}
+
+		return v, nil
+	}
+
+	var result Basic
+	config := &DecoderConfig{
+		DecodeHook: ComposeDecodeHookFunc(decodeHook, StringToTimeDurationHookFunc()),
+		Result:     &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	if result.Vstring != "default" {
+		t.Errorf("vstring should be 'default': %#v", result.Vstring)
+	}
+}
+
+func TestDecode_NonStruct(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"foo": "bar",
+		"bar": "baz",
+	}
+
+	var result map[string]string
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result["foo"] != "bar" {
+		t.Fatal("foo is not bar")
+	}
+}
+
+func TestDecode_StructMatch(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vbar": Basic{
+			Vstring: "foo",
+		},
+	}
+
+	var result Nested
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got an err: %s", err.Error())
+	}
+
+	if result.Vbar.Vstring != "foo" {
+		t.Errorf("bad: %#v", result)
+	}
+}
+
+func TestDecode_TypeConversion(t *testing.T) {
+	input := map[string]interface{}{
+		"IntToFloat":         42,
+		"IntToUint":          42,
+		"IntToBool":          1,
+		"IntToString":        42,
+		"UintToInt":          42,
 		"UintToFloat":        42,
 		"UintToBool":         42,
 		"UintToString":       42,
@@ -449,90 +1376,872 @@ func TestDecode_TypeConversion(t *testing.T) {
 		"MapToSlice":         map[string]interface{}{},
 	}
 
-	expectedResultStrict := TypeConversionResult{
-		IntToFloat:  42.0,
-		IntToUint:   42,
-		UintToInt:   42,
-		UintToFloat: 42,
-		BoolToInt:   0,
-		BoolToUint:  0,
-		BoolToFloat: 0,
-		FloatToInt:  42,
-		FloatToUint: 42,
+	expectedResultStrict := TypeConversionResult{
+		IntToFloat:  42.0,
+		IntToUint:   42,
+		UintToInt:   42,
+		UintToFloat: 42,
+		BoolToInt:   0,
+		BoolToUint:  0,
+		BoolToFloat: 0,
+		FloatToInt:  42,
+		FloatToUint: 42,
+	}
+
+	expectedResultWeak := TypeConversionResult{
+		IntToFloat:         42.0,
+		IntToUint:          42,
+		IntToBool:          true,
+		IntToString:        "42",
+		UintToInt:          42,
+		UintToFloat:        42,
+		UintToBool:         true,
+		UintToString:       "42",
+		BoolToInt:          1,
+		BoolToUint:         1,
+		BoolToFloat:        1,
+		BoolToString:       "1",
+		FloatToInt:         42,
+		FloatToUint:        42,
+		FloatToBool:        true,
+		FloatToString:      "42.42",
+		SliceUint8ToString: "foo",
+		StringToInt:        42,
+		StringToUint:       42,
+		StringToBool:       true,
+		StringToFloat:      42.42,
+		SliceToMap:         map[string]interface{}{},
+		MapToSlice:         []interface{}{},
+	}
+
+	// Test strict type conversion
+	var resultStrict TypeConversionResult
+	err := Decode(input, &resultStrict)
+	if err == nil {
+		t.Errorf("should return an error")
+	}
+	if !reflect.DeepEqual(resultStrict, expectedResultStrict) {
+		t.Errorf("expected %v, got: %v", expectedResultStrict, resultStrict)
+	}
+
+	// Test weak type conversion
+	var decoder *Decoder
+	var resultWeak TypeConversionResult
+
+	config := &DecoderConfig{
+		WeaklyTypedInput: true,
+		Result:           &resultWeak,
+	}
+
+	decoder, err = NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(input)
+	if err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	if !reflect.DeepEqual(resultWeak, expectedResultWeak) {
+		t.Errorf("expected \n%#v, got: \n%#v", expectedResultWeak, resultWeak)
+	}
+}
+
+func TestDecode_WeakConversions(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vint":   "42",
+		"vfloat": "42.5",
+		"vbool":  "true",
+		"vuint":  -1,
+	}
+
+	var result Basic
+	config := &DecoderConfig{
+		WeakConversions: WeakStringToNumber,
+		Result:          &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(input)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	derr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("error should be kind of Error, instead: %#v", err)
+	}
+	if len(derr.Errors) != 2 {
+		t.Fatalf("expected 2 errors, got: %s", err)
+	}
+	if !strings.Contains(err.Error(), "cannot parse 'Vuint', -1 overflows uint") {
+		t.Errorf("expected overflow error, got: %s", err)
+	}
+	if !strings.Contains(err.Error(), "'Vbool' expected type 'bool'") {
+		t.Errorf("expected bool error, got: %s", err)
+	}
+
+	if result.Vint != 42 {
+		t.Errorf("vint should be 42: %#v", result.Vint)
+	}
+	if result.Vfloat != 42.5 {
+		t.Errorf("vfloat should be 42.5: %#v", result.Vfloat)
+	}
+}
+
+func TestDecode_BoolVocabulary(t *testing.T) {
+	t.Parallel()
+
+	type Switches struct {
+		Debug   bool
+		Verbose bool
+		Color   bool `mapstructure:"color,true=always,false=never"`
+	}
+
+	input := map[string]interface{}{
+		"debug":   "Yes",
+		"verbose": "OFF",
+		"color":   "Always",
+	}
+
+	var result Switches
+	config := &DecoderConfig{
+		BoolVocabulary: ExtendedBoolVocabulary(),
+		Result:         &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Switches{Debug: true, Verbose: false, Color: true}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// The field's words replace the configured ones
+	input = map[string]interface{}{
+		"debug": "maybe",
+		"color": "on",
+	}
+
+	err = decoder.Decode(input)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), `cannot parse 'Debug' as bool: "maybe" is not one of (true: 1, t, true, y, yes, on, enable, enabled; false: 0, f, false, n, no, off, disable, disabled)`) {
+		t.Errorf("got unexpected error: %s", err)
+	}
+	if !strings.Contains(err.Error(), `cannot parse 'color' as bool: "on" is not one of (true: always; false: never)`) {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	// An empty string isn't in the vocabulary unless weak mode is on
+	result = Switches{Debug: true, Color: true}
+	err = decoder.Decode(map[string]interface{}{"debug": "", "color": ""})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), `cannot parse 'Debug' as bool: "" is not one of`) {
+		t.Errorf("got unexpected error: %s", err)
+	}
+	if !strings.Contains(err.Error(), `cannot parse 'color' as bool: "" is not one of`) {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	config.WeaklyTypedInput = true
+	decoder, err = NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(map[string]interface{}{"debug": "", "color": ""}); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if result.Debug || result.Color {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// The presets are copies
+	ExtendedBoolVocabulary().True[0] = "changed"
+	if ExtendedBoolVocabulary().True[0] != "1" || StandardBoolVocabulary().True[0] != "1" {
+		t.Fatal("preset was changed")
+	}
+}
+
+func TestDecode_TypeRegistry(t *testing.T) {
+	t.Parallel()
+
+	registry := NewTypeRegistry("")
+	registry.Register("circle", Circle{})
+	registry.Register("square", Square{})
+
+	input := map[string]interface{}{
+		"main": map[string]interface{}{"type": "circle", "radius": 2},
+		"list": []interface{}{
+			map[string]interface{}{"type": "square", "side": 3},
+			map[interface{}]interface{}{"type": "circle", "radius": 1},
+		},
+		"named": map[string]interface{}{
+			"big": map[string]interface{}{"type": "square", "side": 10},
+		},
+	}
+
+	var result Shapes
+	config := &DecoderConfig{
+		ErrorUnused:  true,
+		TypeRegistry: registry,
+		Result:       &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Shapes{
+		Main:  Circle{Radius: 2},
+		List:  []Shape{&Square{Side: 3}, Circle{Radius: 1}},
+		Named: map[string]Shape{"big": &Square{Side: 10}},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	input = map[string]interface{}{
+		"main": map[string]interface{}{"type": "triangle"},
+	}
+
+	err = decoder.Decode(input)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "'Main' has unknown type 'triangle'") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	// The discriminator is found in maps with named string keys
+	type Key string
+	result = Shapes{}
+	input = map[string]interface{}{
+		"main": map[Key]interface{}{"type": "square", "side": 4},
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if !reflect.DeepEqual(result.Main, &Square{Side: 4}) {
+		t.Fatalf("bad: %#v", result.Main)
+	}
+}
+
+func TestDecode_UnusedNonStringKeys(t *testing.T) {
+	t.Parallel()
+
+	input := map[interface{}]interface{}{
+		"vstring": "foo",
+		1:         "bar",
+	}
+
+	var md Metadata
+	var result Basic
+	decoder, err := NewDecoder(&DecoderConfig{Metadata: &md, Result: &result})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if !reflect.DeepEqual(md.Unused, []string{"1"}) {
+		t.Fatalf("bad unused: %#v", md.Unused)
+	}
+
+	decoder, err = NewDecoder(&DecoderConfig{ErrorUnused: true, Result: &result})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(input)
+	if err == nil || !strings.Contains(err.Error(), "'' has invalid keys: 1") {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+}
+
+func TestDecode_InterfaceHoldingValue(t *testing.T) {
+	t.Parallel()
+
+	type Plugin struct {
+		Name   string
+		Config interface{}
+	}
+
+	input := map[string]interface{}{
+		"name":   "foo",
+		"config": map[string]interface{}{"vstring": "bar"},
+	}
+
+	config := &Basic{Vuint: 100}
+	result := Plugin{Config: config}
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	if result.Config != config {
+		t.Fatalf("config pointer should be kept: %#v", result.Config)
+	}
+	expected := Basic{Vstring: "bar", Vuint: 100}
+	if !reflect.DeepEqual(*config, expected) {
+		t.Fatalf("bad: %#v", *config)
+	}
+
+	result = Plugin{Config: Basic{Vuint: 100}}
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	if !reflect.DeepEqual(result.Config, expected) {
+		t.Fatalf("bad: %#v", result.Config)
+	}
+
+	// A value of the held type replaces it
+	replacement := &Basic{Vint: 42}
+	input["config"] = replacement
+	result = Plugin{Config: config}
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	if result.Config != replacement {
+		t.Fatalf("config should be replaced: %#v", result.Config)
+	}
+
+	// The key of the interface is marked as used once
+	var md Metadata
+	result = Plugin{Config: &Basic{}}
+	decoder, err := NewDecoder(&DecoderConfig{Metadata: &md, Result: &result})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input = map[string]interface{}{
+		"config": map[string]interface{}{"vstring": "bar"},
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expectedKeys := []string{"Config.Vstring", "Config"}
+	if !reflect.DeepEqual(md.Keys, expectedKeys) {
+		t.Fatalf("bad keys: %#v", md.Keys)
+	}
+}
+
+func TestDecode_MetadataKeysRepeated(t *testing.T) {
+	t.Parallel()
+
+	type Inner struct {
+		A int
+	}
+
+	type Outer struct {
+		P *Inner
+		M map[string]int
+	}
+
+	var md Metadata
+	var result Outer
+	decoder, err := NewDecoder(&DecoderConfig{Metadata: &md, Result: &result})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input := map[string]interface{}{
+		"p": map[string]interface{}{"a": 1},
+		"m": map[string]interface{}{"k": 2},
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	// Pointers mark their key for the pointer and for the value
+	expected := []string{"P.A", "P", "P", "M[k]", "M[k]", "M"}
+	if !reflect.DeepEqual(md.Keys, expected) {
+		t.Fatalf("bad keys: %#v", md.Keys)
+	}
+
+	// A reused decoder marks the keys again
+	md.Keys = nil
+	if err := decoder.Decode(map[string]interface{}{"m": map[string]interface{}{}}); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if err := decoder.Decode(map[string]interface{}{"m": map[string]interface{}{}}); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if !reflect.DeepEqual(md.Keys, []string{"M", "M"}) {
+		t.Fatalf("bad keys: %#v", md.Keys)
+	}
+}
+
+func TestDecode_Tuple(t *testing.T) {
+	t.Parallel()
+
+	type Point struct {
+		X, Y, Z int
+	}
+
+	type Route struct {
+		_      struct{} `mapstructure:",tuple"`
+		Path   string   `mapstructure:",index=1"`
+		Method string   `mapstructure:",index=0"`
+		Status int      `mapstructure:",index=2"`
+	}
+
+	type Tuples struct {
+		Origin Point   `mapstructure:"origin,tuple"`
+		Points []Point `mapstructure:"points,tuple"`
+		Route  Route
+	}
+
+	input := map[string]interface{}{
+		"origin": []int{1, 2, 3},
+		"points": []interface{}{[]int{4, 5, 6}},
+		"route":  []interface{}{"GET", "/path", 200},
+	}
+
+	var result Tuples
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Tuples{
+		Origin: Point{1, 2, 3},
+		Points: []Point{{4, 5, 6}},
+		Route:  Route{Path: "/path", Method: "GET", Status: 200},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	input = map[string]interface{}{
+		"origin": []int{1, 2},
+		"route":  []interface{}{"GET", "/path", "ok"},
+	}
+
+	err := Decode(input, &result)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "'origin' expected 3 elements, got 2") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+	if !strings.Contains(err.Error(), "'Route[2]' expected type 'int'") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	// Elements are decoded in order
+	var md Metadata
+	var route Route
+	decoder, err := NewDecoder(&DecoderConfig{Metadata: &md, Result: &route})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode([]interface{}{"GET", "/path", 200}); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expectedKeys := []string{"[0]", "[1]", "[2]"}
+	if !reflect.DeepEqual(md.Keys, expectedKeys) {
+		t.Fatalf("bad keys: %#v", md.Keys)
+	}
+}
+
+func TestDecode_PrimaryField(t *testing.T) {
+	t.Parallel()
+
+	type Image struct {
+		Name string `mapstructure:"name,primary"`
+		Pull string
+	}
+
+	type Service struct {
+		Image  Image
+		Images []Image
+	}
+
+	input := map[string]interface{}{
+		"image": "nginx:1.25",
+		"images": []interface{}{
+			"redis",
+			map[string]interface{}{"name": "postgres", "pull": "never"},
+		},
+	}
+
+	result := Service{
+		Image: Image{Pull: "always"},
+	}
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Service{
+		Image: Image{Name: "nginx:1.25", Pull: "always"},
+		Images: []Image{
+			{Name: "redis"},
+			{Name: "postgres", Pull: "never"},
+		},
 	}
-
-	expectedResultWeak := TypeConversionResult{
-		IntToFloat:         42.0,
-		IntToUint:          42,
-		IntToBool:          true,
-		IntToString:        "42",
-		UintToInt:          42,
-		UintToFloat:        42,
-		UintToBool:         true,
-		UintToString:       "42",
-		BoolToInt:          1,
-		BoolToUint:         1,
-		BoolToFloat:        1,
-		BoolToString:       "1",
-		FloatToInt:         42,
-		FloatToUint:        42,
-		FloatToBool:        true,
-		FloatToString:      "42.42",
-		SliceUint8ToString: "foo",
-		StringToInt:        42,
-		StringToUint:       42,
-		StringToBool:       true,
-		StringToFloat:      42.42,
-		SliceToMap:         map[string]interface{}{},
-		MapToSlice:         []interface{}{},
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
 	}
 
-	// Test strict type conversion
-	var resultStrict TypeConversionResult
-	err := Decode(input, &resultStrict)
+	err := Decode(map[string]interface{}{"image": 42}, &result)
 	if err == nil {
-		t.Errorf("should return an error")
+		t.Fatal("expected error")
 	}
-	if !reflect.DeepEqual(resultStrict, expectedResultStrict) {
-		t.Errorf("expected %v, got: %v", expectedResultStrict, resultStrict)
+	if !strings.Contains(err.Error(), "'Image.name' expected type 'string'") {
+		t.Errorf("got unexpected error: %s", err)
 	}
+}
 
-	// Test weak type conversion
-	var decoder *Decoder
-	var resultWeak TypeConversionResult
+func TestDecoder_ErrorUnused(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vstring": "hello",
+		"foo":     "bar",
+	}
 
+	var result Basic
 	config := &DecoderConfig{
-		WeaklyTypedInput: true,
-		Result:           &resultWeak,
+		ErrorUnused: true,
+		Result:      &result,
 	}
 
-	decoder, err = NewDecoder(config)
+	decoder, err := NewDecoder(config)
 	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
 
 	err = decoder.Decode(input)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestMap(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vfoo": "foo",
+		"vother": map[interface{}]interface{}{
+			"foo": "foo",
+			"bar": "bar",
+		},
+	}
+
+	var result Map
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got an error: %s", err)
+	}
+
+	if result.Vfoo != "foo" {
+		t.Errorf("vfoo value should be 'foo': %#v", result.Vfoo)
+	}
+
+	if result.Vother == nil {
+		t.Fatal("vother should not be nil")
+	}
+
+	if len(result.Vother) != 2 {
+		t.Error("vother should have two items")
+	}
+
+	if result.Vother["foo"] != "foo" {
+		t.Errorf("'foo' key should be foo, got: %#v", result.Vother["foo"])
+	}
+
+	if result.Vother["bar"] != "bar" {
+		t.Errorf("'bar' key should be bar, got: %#v", result.Vother["bar"])
+	}
+}
+
+func TestMapMerge(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vfoo": "foo",
+		"vother": map[interface{}]interface{}{
+			"foo": "foo",
+			"bar": "bar",
+		},
+	}
+
+	var result Map
+	result.Vother = map[string]string{"hello": "world"}
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got an error: %s", err)
+	}
+
+	if result.Vfoo != "foo" {
+		t.Errorf("vfoo value should be 'foo': %#v", result.Vfoo)
+	}
+
+	expected := map[string]string{
+		"foo":   "foo",
+		"bar":   "bar",
+		"hello": "world",
+	}
+	if !reflect.DeepEqual(result.Vother, expected) {
+		t.Errorf("bad: %#v", result.Vother)
+	}
+}
+
+func TestMapMerge_Deep(t *testing.T) {
+	t.Parallel()
+
+	type Services struct {
+		Values   map[string]Basic
+		Pointers map[string]*Basic
+		Nested   map[string]map[string]string
+	}
+
+	input := map[string]interface{}{
+		"values": map[string]interface{}{
+			"foo": map[string]interface{}{"vstring": "one"},
+			"bar": map[string]interface{}{"vstring": "two"},
+		},
+		"pointers": map[string]interface{}{
+			"foo": map[string]interface{}{"vstring": "one"},
+		},
+		"nested": map[string]interface{}{
+			"foo": map[string]interface{}{"a": "new"},
+		},
+	}
+
+	result := Services{
+		Values: map[string]Basic{
+			"foo": {Vstring: "old", Vint: 42},
+		},
+		Pointers: map[string]*Basic{
+			"foo": {Vint: 42},
+		},
+		Nested: map[string]map[string]string{
+			"foo": {"a": "old", "b": "kept"},
+		},
+	}
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Services{
+		Values: map[string]Basic{
+			"foo": {Vstring: "one", Vint: 42},
+			"bar": {Vstring: "two"},
+		},
+		Pointers: map[string]*Basic{
+			"foo": {Vstring: "one", Vint: 42},
+		},
+		Nested: map[string]map[string]string{
+			"foo": {"a": "new", "b": "kept"},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestMap_KeyConversion(t *testing.T) {
+	t.Parallel()
+
+	type Keys struct {
+		Ints   map[int]string
+		Ports  map[uint16]string
+		Addrs  map[netip.Addr]string
+		Levels map[Level]string
+		Flags  map[bool]string
+	}
+
+	input := map[string]interface{}{
+		"ints":   map[string]interface{}{"1": "one", "-2": "minus two"},
+		"ports":  map[string]interface{}{"443": "https"},
+		"addrs":  map[string]interface{}{"10.0.0.1": "gateway"},
+		"levels": map[string]interface{}{"high": "alert"},
+		"flags":  map[interface{}]interface{}{"true": "yes"},
+	}
+
+	var result Keys
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Keys{
+		Ints:   map[int]string{1: "one", -2: "minus two"},
+		Ports:  map[uint16]string{443: "https"},
+		Addrs:  map[netip.Addr]string{netip.MustParseAddr("10.0.0.1"): "gateway"},
+		Levels: map[Level]string{2: "alert"},
+		Flags:  map[bool]string{true: "yes"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	input = map[string]interface{}{
+		"ports":  map[string]interface{}{"70000": "bad"},
+		"levels": map[string]interface{}{"medium": "bad"},
+	}
+
+	err := Decode(input, &result)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "cannot parse key 'Ports[70000]' as uint16") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+	if !strings.Contains(err.Error(), `cannot parse key 'Levels[medium]' as mapstructure.Level: unknown level "medium"`) {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	// Hooks see the keys before they are converted
+	var ints map[int]string
+	config := &DecoderConfig{
+		DecodeHook: func(from reflect.Type, to reflect.Type, v interface{}) (interface{}, error) {
+			if v == "one" && to.Kind() == reflect.Int {
+				return 1, nil
+			}
+
+			return v, nil
+		},
+		Result: &ints,
+	}
+
+	decoder, err := NewDecoder(config)
 	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(map[string]string{"one": "a", "2": "b"}); err != nil {
 		t.Fatalf("got an err: %s", err)
 	}
 
-	if !reflect.DeepEqual(resultWeak, expectedResultWeak) {
-		t.Errorf("expected \n%#v, got: \n%#v", expectedResultWeak, resultWeak)
+	if !reflect.DeepEqual(ints, map[int]string{1: "a", 2: "b"}) {
+		t.Fatalf("bad: %#v", ints)
 	}
 }
 
-func TestDecoder_ErrorUnused(t *testing.T) {
+func TestMap_Set(t *testing.T) {
 	t.Parallel()
 
+	type Sets struct {
+		Allow   map[string]struct{}
+		Enabled map[string]bool
+		Ports   map[int]struct{}
+	}
+
 	input := map[string]interface{}{
-		"vstring": "hello",
-		"foo":     "bar",
+		"allow":   []interface{}{"a", "b"},
+		"enabled": []string{"x"},
+		"ports":   []interface{}{80, "443"},
+	}
+
+	result := Sets{
+		Enabled: map[string]bool{"y": false},
+	}
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Sets{
+		Allow:   map[string]struct{}{"a": {}, "b": {}},
+		Enabled: map[string]bool{"x": true, "y": false},
+		Ports:   map[int]struct{}{80: {}, 443: {}},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// Duplicates are an error if asked for
+	input = map[string]interface{}{
+		"allow": []interface{}{"a", "b", "a"},
+	}
+
+	result = Sets{}
+	config := &DecoderConfig{
+		ErrorDuplicates: true,
+		Result:          &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(input)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "'Allow[2]' has duplicate element 'a'") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+}
+
+func TestMap_SetSliceOfMaps(t *testing.T) {
+	t.Parallel()
+
+	// A list of maps is still merged in weak mode, not decoded as a set
+	input := []interface{}{
+		map[string]interface{}{"a": true},
+		map[string]interface{}{"b": false},
+	}
+
+	var result map[string]bool
+	if err := WeakDecode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := map[string]bool{"a": true, "b": false}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestMap_PairList(t *testing.T) {
+	t.Parallel()
+
+	type Pairs struct {
+		Labels map[string]int
+		Flags  map[string]string `mapstructure:"flags,pairs"`
+		Limits map[string]int    `mapstructure:"limits,pairs"`
+	}
+
+	input := map[string]interface{}{
+		"labels": []interface{}{
+			map[string]interface{}{"name": "a", "count": 1},
+			map[string]interface{}{"name": "b", "count": 2},
+		},
 	}
 
-	var result Basic
+	var result Pairs
 	config := &DecoderConfig{
-		ErrorUnused: true,
-		Result:      &result,
+		PairList: &PairList{KeyField: "name", ValueField: "count"},
+		Result:   &result,
 	}
 
 	decoder, err := NewDecoder(config)
@@ -540,79 +2249,120 @@ func TestDecoder_ErrorUnused(t *testing.T) {
 		t.Fatalf("err: %s", err)
 	}
 
-	err = decoder.Decode(input)
-	if err == nil {
-		t.Fatal("expected error")
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
 	}
-}
 
-func TestMap(t *testing.T) {
-	t.Parallel()
+	if !reflect.DeepEqual(result.Labels, map[string]int{"a": 1, "b": 2}) {
+		t.Fatalf("bad: %#v", result.Labels)
+	}
 
-	input := map[string]interface{}{
-		"vfoo": "foo",
-		"vother": map[interface{}]interface{}{
-			"foo": "foo",
-			"bar": "bar",
+	// The tag option uses the default names and separator
+	input = map[string]interface{}{
+		"flags": []interface{}{
+			"a=1",
+			map[string]interface{}{"key": "b", "value": "2"},
 		},
 	}
 
-	var result Map
-	err := Decode(input, &result)
-	if err != nil {
-		t.Fatalf("got an error: %s", err)
+	result = Pairs{}
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
 	}
 
-	if result.Vfoo != "foo" {
-		t.Errorf("vfoo value should be 'foo': %#v", result.Vfoo)
+	if !reflect.DeepEqual(result.Flags, map[string]string{"a": "1", "b": "2"}) {
+		t.Fatalf("bad: %#v", result.Flags)
 	}
 
-	if result.Vother == nil {
-		t.Fatal("vother should not be nil")
+	// Values of pairs given as strings are parsed
+	input = map[string]interface{}{
+		"limits": []interface{}{"a=1", "b=2"},
 	}
 
-	if len(result.Vother) != 2 {
-		t.Error("vother should have two items")
+	result = Pairs{}
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
 	}
 
-	if result.Vother["foo"] != "foo" {
-		t.Errorf("'foo' key should be foo, got: %#v", result.Vother["foo"])
+	if !reflect.DeepEqual(result.Limits, map[string]int{"a": 1, "b": 2}) {
+		t.Fatalf("bad: %#v", result.Limits)
 	}
 
-	if result.Vother["bar"] != "bar" {
-		t.Errorf("'bar' key should be bar, got: %#v", result.Vother["bar"])
+	input = map[string]interface{}{
+		"labels": []interface{}{"a=1", "b=2"},
+	}
+
+	result = Pairs{}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	if !reflect.DeepEqual(result.Labels, map[string]int{"a": 1, "b": 2}) {
+		t.Fatalf("bad: %#v", result.Labels)
+	}
+
+	input = map[string]interface{}{
+		"flags": []interface{}{"a", map[string]interface{}{"value": "2"}, 42},
 	}
+
+	err = Decode(input, &result)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, expected := range []string{
+		"'flags[0]' expected key=value, got 'a'",
+		"'flags[1]' is missing the key 'key'",
+		"'flags[2]' expected a key/value pair, got 'int'",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q, got: %s", expected, err)
+		}
+	}
+
 }
 
-func TestMapMerge(t *testing.T) {
+func TestMap_PairListOtherLists(t *testing.T) {
 	t.Parallel()
 
+	type Lists struct {
+		Allow  map[string]bool
+		Labels map[string]int
+		Env    map[string]string
+	}
+
+	// The PairList option leaves sets and lists that aren't pairs alone
 	input := map[string]interface{}{
-		"vfoo": "foo",
-		"vother": map[interface{}]interface{}{
-			"foo": "foo",
-			"bar": "bar",
+		"allow": []interface{}{"a", "b"},
+		"labels": []interface{}{
+			map[string]interface{}{"a": 1},
+			map[string]interface{}{"b": 2},
 		},
+		"env": []interface{}{"HOME=/root"},
 	}
 
-	var result Map
-	result.Vother = map[string]string{"hello": "world"}
-	err := Decode(input, &result)
+	var result Lists
+	config := &DecoderConfig{
+		PairList:         &PairList{},
+		WeaklyTypedInput: true,
+		Result:           &result,
+	}
+
+	decoder, err := NewDecoder(config)
 	if err != nil {
-		t.Fatalf("got an error: %s", err)
+		t.Fatalf("err: %s", err)
 	}
 
-	if result.Vfoo != "foo" {
-		t.Errorf("vfoo value should be 'foo': %#v", result.Vfoo)
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
 	}
 
-	expected := map[string]string{
-		"foo":   "foo",
-		"bar":   "bar",
-		"hello": "world",
+	expected := Lists{
+		Allow:  map[string]bool{"a": true, "b": true},
+		Labels: map[string]int{"a": 1, "b": 2},
+		Env:    map[string]string{"HOME": "/root"},
 	}
-	if !reflect.DeepEqual(result.Vother, expected) {
-		t.Errorf("bad: %#v", result.Vother)
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
 	}
 }
 
@@ -764,6 +2514,324 @@ func TestInvalidSlice(t *testing.T) {
 	}
 }
 
+func TestSlice_CoerceSingletons(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vfoo": []interface{}{"foo"},
+		"vbar": "bar",
+	}
+
+	var result Slice
+	config := &DecoderConfig{
+		CoerceSingletons: true,
+		Result:           &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Slice{
+		Vfoo: "foo",
+		Vbar: []string{"bar"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// Element conversions and errors still apply
+	input = map[string]interface{}{
+		"vfoo": []interface{}{"foo", "bar"},
+		"vbar": 42,
+	}
+
+	result = Slice{}
+	config = &DecoderConfig{
+		CoerceSingletons: true,
+		Result:           &result,
+	}
+
+	decoder, err = NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(input)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "'Vbar[0]' expected type 'string'") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+	if !strings.Contains(err.Error(), "'Vfoo' expected type 'string'") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	// A singleton holding nil is nil data
+	var basic Basic
+	config = &DecoderConfig{
+		CoerceSingletons: true,
+		NilBehavior:      NilError,
+		Result:           &basic,
+	}
+
+	decoder, err = NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(map[string]interface{}{"vstring": []interface{}{nil}})
+	if err == nil || !strings.Contains(err.Error(), "'Vstring' must not be null") {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+
+	basic = Basic{Vstring: "keep"}
+	config.NilBehavior = NilIgnore
+	err = decoder.Decode(map[string]interface{}{"vstring": []interface{}{nil}})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if basic.Vstring != "keep" {
+		t.Fatalf("bad: %#v", basic)
+	}
+}
+
+func TestSlice_Separator(t *testing.T) {
+	t.Parallel()
+
+	type Lists struct {
+		Hosts  []string       `mapstructure:"hosts,sep=;"`
+		Ports  []int          `mapstructure:"ports,trim"`
+		Limits map[string]int `mapstructure:"limits,sep,trim"`
+	}
+
+	input := map[string]interface{}{
+		"hosts":  "a.example.com;b.example.com",
+		"ports":  "1, 2, 3",
+		"limits": "a=1, b = 2",
+	}
+
+	var result Lists
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Lists{
+		Hosts:  []string{"a.example.com", "b.example.com"},
+		Ports:  []int{1, 2, 3},
+		Limits: map[string]int{"a": 1, "b": 2},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	input = map[string]interface{}{
+		"ports":  "1,x",
+		"limits": "a=1,b",
+	}
+
+	err = Decode(input, &result)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "cannot parse 'ports[1]' as int") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+	if !strings.Contains(err.Error(), "'limits' expected key=value, got 'b'") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	// Only split elements are parsed; other strings are not
+	result = Lists{}
+	input = map[string]interface{}{
+		"ports":  []interface{}{"1"},
+		"limits": map[string]interface{}{"a": "1"},
+	}
+
+	err = Decode(input, &result)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "'ports[0]' expected type 'int', got unconvertible type 'string'") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+	if !strings.Contains(err.Error(), "'limits[a]' expected type 'int', got unconvertible type 'string'") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+}
+
+func TestSlice_Bytes(t *testing.T) {
+	t.Parallel()
+
+	type Keys struct {
+		Raw    []byte
+		Hex    [4]byte `mapstructure:"hex,encoding=hex"`
+		Std    []byte  `mapstructure:"std,encoding=base64"`
+		URL    []byte  `mapstructure:"url,encoding=base64url"`
+		RawStd []byte  `mapstructure:"rawstd,encoding=base64raw"`
+	}
+
+	input := map[string]interface{}{
+		"raw":    "foo",
+		"hex":    "deadbeef",
+		"std":    "Zm9vYg==",
+		"url":    "-_8=",
+		"rawstd": "Zm9vYg",
+	}
+
+	var result Keys
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Keys{
+		Raw:    []byte("foo"),
+		Hex:    [4]byte{0xde, 0xad, 0xbe, 0xef},
+		Std:    []byte("foob"),
+		URL:    []byte{0xfb, 0xff},
+		RawStd: []byte("foob"),
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	input = map[string]interface{}{
+		"hex": "dead",
+		"std": "not base64!",
+	}
+
+	err = Decode(input, &result)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "'hex' expected 4 bytes, got 2") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+	if !strings.Contains(err.Error(), "cannot decode 'std' as base64: illegal base64 data") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+}
+
+func TestArray(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vfoo": []string{"foo", "bar"},
+	}
+
+	var result struct {
+		Vfoo [3]string
+	}
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	if result.Vfoo != [3]string{"foo", "bar", ""} {
+		t.Errorf("bad: %#v", result.Vfoo)
+	}
+
+	input = map[string]interface{}{
+		"vfoo": []string{"a", "b", "c", "d"},
+	}
+
+	if err := Decode(input, &result); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestSlice_Merge(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vbar": []string{"baz"},
+	}
+
+	cases := []struct {
+		strategy SliceMerge
+		expected []string
+	}{
+		{SliceReplace, []string{"baz"}},
+		{SliceAppend, []string{"foo", "bar", "baz"}},
+		{SliceMergeIndex, []string{"baz", "bar"}},
+	}
+
+	for i, tc := range cases {
+		result := Slice{Vbar: []string{"foo", "bar"}}
+		config := &DecoderConfig{
+			SliceMerge: tc.strategy,
+			Result:     &result,
+		}
+
+		decoder, err := NewDecoder(config)
+		if err != nil {
+			t.Fatalf("case %d: err: %s", i, err)
+		}
+
+		if err := decoder.Decode(input); err != nil {
+			t.Fatalf("case %d: got an err: %s", i, err)
+		}
+
+		if !reflect.DeepEqual(result.Vbar, tc.expected) {
+			t.Errorf("case %d: expected %#v, got %#v", i, tc.expected, result.Vbar)
+		}
+	}
+}
+
+func TestSlice_MergeKey(t *testing.T) {
+	t.Parallel()
+
+	type Server struct {
+		Name string
+		Port int
+		TLS  bool
+	}
+
+	type Config struct {
+		Servers []Server `mapstructure:"servers,mergekey=name"`
+		Ports   []int    `mapstructure:"ports,merge=index"`
+	}
+
+	input := map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"name": "b", "port": 8443},
+			map[string]interface{}{"name": "c", "port": 9000},
+		},
+		"ports": []int{1},
+	}
+
+	result := Config{
+		Servers: []Server{
+			{Name: "a", Port: 80},
+			{Name: "b", Port: 443, TLS: true},
+		},
+		Ports: []int{10, 20},
+	}
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Config{
+		Servers: []Server{
+			{Name: "a", Port: 80},
+			{Name: "b", Port: 8443, TLS: true},
+			{Name: "c", Port: 9000},
+		},
+		Ports: []int{1, 20},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
 func TestSliceOfStruct(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,56 @@
+package mapstructure
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// Optional holds a value along with whether its key was present in the
+// input and whether it was null. This is useful for PATCH-style input,
+// where a missing key, a null and a value all mean different things.
+//
+// The decoder understands Optional natively: a missing key leaves it
+// untouched (Present is false), a nil sets Present and Null and zeroes
+// Value, and anything else sets Present and is decoded into Value as if
+// the field had type T.
+type Optional[T any] struct {
+	Value   T
+	Present bool
+	Null    bool
+}
+
+// optional is implemented by *Optional[T], so that the decoder can
+// recognize an Optional without knowing T.
+type optional interface {
+	// setPresent marks the Optional as present and returns its Value.
+	setPresent(null bool) reflect.Value
+}
+
+var optionalType = reflect.TypeOf((*optional)(nil)).Elem()
+
+func (o *Optional[T]) setPresent(null bool) reflect.Value {
+	var zero T
+	o.Present = true
+	o.Null = null
+	if null {
+		o.Value = zero
+	}
+
+	return reflect.ValueOf(&o.Value).Elem()
+}
+
+// IsZero reports whether the value is missing. From Go 1.24 on, this
+// makes encoding/json omit it from fields tagged with omitzero.
+func (o Optional[T]) IsZero() bool {
+	return !o.Present
+}
+
+// MarshalJSON encodes a null or missing value as null, and anything else
+// as its Value.
+func (o Optional[T]) MarshalJSON() ([]byte, error) {
+	if o.Null || !o.Present {
+		return []byte("null"), nil
+	}
+
+	return json.Marshal(o.Value)
+}
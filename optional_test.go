@@ -0,0 +1,113 @@
+package mapstructure
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type Patch struct {
+	Name    Optional[string]   `json:"name"`
+	Timeout Optional[*int]     `json:"timeout"`
+	Tags    Optional[[]string] `json:"tags"`
+}
+
+func TestOptional(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"name":    "foo",
+		"timeout": nil,
+	}
+
+	var result Patch
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Patch{
+		Name:    Optional[string]{Value: "foo", Present: true},
+		Timeout: Optional[*int]{Present: true, Null: true},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestOptional_NilBehavior(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"name": nil,
+	}
+
+	result := Patch{
+		Name: Optional[string]{Value: "foo", Present: true},
+	}
+	config := &DecoderConfig{
+		NilBehavior: NilError,
+		Result:      &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Optional[string]{Present: true, Null: true}
+	if result.Name != expected {
+		t.Fatalf("bad: %#v", result.Name)
+	}
+}
+
+func TestOptional_ElementError(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"tags": []interface{}{"a", 42},
+	}
+
+	var result Patch
+	err := Decode(input, &result)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	derr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("error should be kind of Error, instead: %#v", err)
+	}
+
+	if len(derr.Errors) != 1 || !strings.HasPrefix(derr.Errors[0], "'Tags[1]' expected type 'string', got unconvertible type 'int'") {
+		t.Errorf("got unexpected error: %s", err)
+	}
+}
+
+func TestOptional_MarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	patch := Patch{
+		Name:    Optional[string]{Value: "foo", Present: true},
+		Timeout: Optional[*int]{Present: true, Null: true},
+	}
+
+	b, err := json.Marshal(patch)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := `{"name":"foo","timeout":null,"tags":null}`
+	if string(b) != expected {
+		t.Fatalf("expected %s, got %s", expected, b)
+	}
+
+	if patch.Name.IsZero() || patch.Timeout.IsZero() || !patch.Tags.IsZero() {
+		t.Fatalf("bad: %#v", patch)
+	}
+}
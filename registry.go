@@ -0,0 +1,78 @@
+package mapstructure
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// TypeRegistry maps the values of a discriminator key to concrete types,
+// so that a map such as {"type": "circle", "radius": 2} can be decoded
+// into an interface value. See TypeRegistry in DecoderConfig.
+type TypeRegistry struct {
+	key   string
+	types map[string]reflect.Type
+}
+
+// NewTypeRegistry returns an empty registry that reads the discriminator
+// from the given map key, or from "type" if key is empty.
+func NewTypeRegistry(key string) *TypeRegistry {
+	if key == "" {
+		key = "type"
+	}
+
+	return &TypeRegistry{
+		key:   key,
+		types: make(map[string]reflect.Type),
+	}
+}
+
+// Register maps the discriminator value name to the type of value, which
+// is usually a zero value such as Circle{} or (*Circle)(nil). If only the
+// pointer type implements the target interface, a pointer is decoded.
+func (r *TypeRegistry) Register(name string, value interface{}) {
+	r.types[name] = reflect.TypeOf(value)
+}
+
+// lookup returns the registered type for the discriminator in data, if
+// data is a map that has one. The type is checked against the interface
+// type of the target.
+func (r *TypeRegistry) lookup(name string, data interface{}, ifaceType reflect.Type) (reflect.Type, bool, error) {
+	dataVal := reflect.Indirect(reflect.ValueOf(data))
+	if dataVal.Kind() != reflect.Map {
+		return nil, false, nil
+	}
+	if kind := dataVal.Type().Key().Kind(); kind != reflect.String && kind != reflect.Interface {
+		return nil, false, nil
+	}
+
+	// The map's keys may be of a named string type
+	key := reflect.ValueOf(r.key)
+	if dataVal.Type().Key().Kind() == reflect.String {
+		key = key.Convert(dataVal.Type().Key())
+	}
+
+	discriminator := dataVal.MapIndex(key)
+	if !discriminator.IsValid() {
+		return nil, false, nil
+	}
+	if discriminator.Kind() == reflect.Interface {
+		discriminator = discriminator.Elem()
+	}
+	if discriminator.Kind() != reflect.String {
+		return nil, false, fmt.Errorf(
+			"'%s' expected '%s' to be a string, got '%s'", name, r.key, discriminator.Kind())
+	}
+
+	typ, ok := r.types[discriminator.String()]
+	if !ok {
+		return nil, false, fmt.Errorf(
+			"'%s' has unknown %s '%s'", name, r.key, discriminator.String())
+	}
+	if !typ.Implements(ifaceType) && !reflect.PtrTo(typ).Implements(ifaceType) {
+		return nil, false, fmt.Errorf(
+			"'%s' type '%s' registered as '%s' does not implement '%s'",
+			name, typ, discriminator.String(), ifaceType)
+	}
+
+	return typ, true, nil
+}
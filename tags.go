@@ -0,0 +1,222 @@
+package mapstructure
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// knownOptions are the tag options that the decoder understands. The
+// documentation for each is with TagName in DecoderConfig.
+var knownOptions = map[string]bool{
+	"alias":      true,
+	"deprecated": true,
+	"encoding":   true,
+	"false":      true,
+	"index":      true,
+	"merge":      true,
+	"mergekey":   true,
+	"nil":        true,
+	"omitempty":  true,
+	"pairs":      true,
+	"prefix":     true,
+	"primary":    true,
+	"sep":        true,
+	"setter":     true,
+	"squash":     true,
+	"trim":       true,
+	"true":       true,
+	"tuple":      true,
+}
+
+// knownEncodings are the values of the "encoding" tag option.
+var knownEncodings = map[string]bool{
+	"":             true,
+	"raw":          true,
+	"hex":          true,
+	"base64":       true,
+	"base64url":    true,
+	"base64raw":    true,
+	"base64rawurl": true,
+}
+
+// fieldTag is the parsed form of a struct field tag such as
+// `mapstructure:"name,squash"`. The first element is the name and the
+// rest are options, which are either flags ("squash") or key/value
+// pairs ("true=yes|on"). Skip is set for the tag "-", and Key is the
+// key of the struct tag it was read from, such as "json".
+type fieldTag struct {
+	Name    string
+	Options map[string]string
+	Skip    bool
+	Key     string
+}
+
+// structTag returns the parsed tag of a struct field, which comes from
+// the first of the configured tag names that the field has.
+func (d *Decoder) structTag(field reflect.StructField) fieldTag {
+	for _, name := range d.config.TagNames {
+		if tag, ok := field.Tag.Lookup(name); ok {
+			result := parseTag(tag)
+			result.Key = name
+			return result
+		}
+	}
+
+	return fieldTag{}
+}
+
+// parseTag parses the value of a struct field tag.
+func parseTag(tag string) fieldTag {
+	if tag == "-" {
+		return fieldTag{Skip: true}
+	}
+
+	parts := strings.Split(tag, ",")
+	result := fieldTag{Name: parts[0]}
+	for _, opt := range parts[1:] {
+		if opt == "" {
+			continue
+		}
+
+		if result.Options == nil {
+			result.Options = make(map[string]string)
+		}
+
+		key, value, _ := strings.Cut(opt, "=")
+		if key == "inline" {
+			// yaml's name for squash
+			key = "squash"
+		}
+		result.Options[key] = value
+	}
+
+	return result
+}
+
+// has reports whether the tag has the given option.
+func (t fieldTag) has(opt string) bool {
+	_, ok := t.Options[opt]
+	return ok
+}
+
+// option returns the value of the given option and whether it is set.
+func (t fieldTag) option(opt string) (string, bool) {
+	v, ok := t.Options[opt]
+	return v, ok
+}
+
+// optionList returns the value of the given option split on "|", as
+// used for options that take several words.
+func (t fieldTag) optionList(opt string) ([]string, bool) {
+	v, ok := t.Options[opt]
+	if !ok || v == "" {
+		return nil, ok
+	}
+
+	return strings.Split(v, "|"), true
+}
+
+// checkTags checks the tags of the fields of every struct reachable from
+// type t, for StrictTags, and appends a message for each problem to
+// errors. seen holds the types that have been checked already.
+func (d *Decoder) checkTags(t reflect.Type, seen map[reflect.Type]bool, errors []string) []string {
+	if seen[t] {
+		return errors
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return d.checkTags(t.Elem(), seen, errors)
+	case reflect.Map:
+		errors = d.checkTags(t.Key(), seen, errors)
+		return d.checkTags(t.Elem(), seen, errors)
+	case reflect.Struct:
+	default:
+		return errors
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := d.structTag(field)
+		if tag.Skip {
+			continue
+		}
+
+		// Tags of other packages, such as json, have options of their own
+		for _, err := range tag.check(field.Type, tag.Key == d.config.TagName) {
+			errors = append(errors, fmt.Sprintf("%s field %s: %s", t, field.Name, err))
+		}
+
+		errors = d.checkTags(field.Type, seen, errors)
+	}
+
+	return errors
+}
+
+// check returns the problems with the options of the tag of a field of
+// the given type. Unknown options are only a problem if unknown is set.
+func (t fieldTag) check(fieldType reflect.Type, unknown bool) []string {
+	var problems []string
+
+	opts := make([]string, 0, len(t.Options))
+	for opt := range t.Options {
+		opts = append(opts, opt)
+	}
+	sort.Strings(opts)
+
+	for _, opt := range opts {
+		if unknown && !knownOptions[opt] {
+			problems = append(problems, fmt.Sprintf("unknown tag option '%s'", opt))
+		}
+	}
+
+	if t.has("squash") {
+		if t.Name != "" {
+			problems = append(problems, fmt.Sprintf("squash conflicts with the name '%s'", t.Name))
+		}
+
+		structType := fieldType
+		if structType.Kind() == reflect.Ptr {
+			structType = structType.Elem()
+		}
+		if structType.Kind() != reflect.Struct {
+			problems = append(problems, fmt.Sprintf("unsupported type for squash: %s", fieldType.Kind()))
+		}
+
+		for _, opt := range []string{"alias", "deprecated"} {
+			if t.has(opt) {
+				problems = append(problems, fmt.Sprintf("squash conflicts with %s", opt))
+			}
+		}
+	} else if t.has("prefix") {
+		problems = append(problems, "prefix requires squash")
+	}
+
+	if v, ok := t.option("nil"); ok {
+		if _, ok := parseNilBehavior(v); !ok {
+			problems = append(problems, fmt.Sprintf("invalid value '%s' for nil", v))
+		}
+	}
+
+	if v, ok := t.option("merge"); ok {
+		if _, ok := parseSliceMerge(v); !ok {
+			problems = append(problems, fmt.Sprintf("invalid value '%s' for merge", v))
+		}
+	}
+
+	if v, ok := t.option("encoding"); ok && !knownEncodings[v] {
+		problems = append(problems, fmt.Sprintf("invalid value '%s' for encoding", v))
+	}
+
+	if v, ok := t.option("index"); ok {
+		if n, err := strconv.Atoi(v); err != nil || n < 0 {
+			problems = append(problems, fmt.Sprintf("invalid value '%s' for index", v))
+		}
+	}
+
+	return problems
+}